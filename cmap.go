@@ -1,11 +1,18 @@
 package cmap
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/maphash"
+	"iter"
+	"math/bits"
 	"sync"
+	"time"
 )
 
+// SHARD_COUNT is the default number of shards used when a map is created.
+// Changing it only affects maps created afterwards.
 var SHARD_COUNT = 32
 
 type Stringer interface {
@@ -19,7 +26,55 @@ type ShardingFunc[K comparable, V any] func(key K) uint32
 // To avoid lock bottlenecks this map is dived to several (SHARD_COUNT) map shards.
 type ConcurrentMap[K comparable, V any] struct {
 	sharding ShardingFunc[K, V]
-	shards   []*SafeMap[K, V]
+	skew     *skewDetector
+	state    *mapState[K, V]
+}
+
+// Options configures a map created by NewWithOptions.
+type Options[K comparable, V any] struct {
+	// ShardCount is the number of shards, rounded up to a power of two.
+	// Zero means SHARD_COUNT.
+	ShardCount int
+	// Sharding maps a key to the hash used to pick its shard.
+	// Zero means the built-in seeded hasher, which supports any comparable key.
+	Sharding ShardingFunc[K, V]
+	// ShardCapacity is the initial capacity of every shard.
+	ShardCapacity int
+	// Seed seeds the built-in hasher. The zero Seed means a random one.
+	Seed maphash.Seed
+	// OnSkew, if set, is called when a shard holds more than SkewFactor
+	// times the mean number of keys per shard, which usually means the
+	// keys were crafted to collide.
+	OnSkew SkewFunc
+	// SkewFactor is the imbalance reported to OnSkew. Zero means 8.
+	SkewFactor float64
+	// GrowThreshold, if positive, makes the map double its shard count in
+	// the background whenever a shard grows beyond GrowThreshold keys.
+	GrowThreshold int
+	// Clock returns the current time used to expire entries set with a TTL.
+	// Zero means time.Now; tests can inject a fake clock.
+	Clock func() time.Time
+	// JanitorInterval is how often the background janitor reclaims expired
+	// entries. Zero means one second.
+	JanitorInterval time.Duration
+	// MaxEntries, if positive, bounds the number of entries. The bound is
+	// split evenly across shards and a shard that is full evicts its least
+	// recently used entry, so the map may evict before holding MaxEntries
+	// entries if keys are unevenly spread. The shard count is lowered to at
+	// most MaxEntries, so that every shard holds at least one entry.
+	MaxEntries int
+	// Admission selects which new keys a map bounded by MaxEntries admits.
+	// Zero means AdmitAll.
+	Admission Admission
+	// MaxCost, if positive, bounds the total cost of the entries. Like
+	// MaxEntries, the budget is split evenly across shards, and a shard
+	// over its budget evicts entries until it is back under it. An entry
+	// costing more than a shard's budget is evicted at once. The shard
+	// count is lowered to at most MaxCost, so that every budget is positive.
+	MaxCost int64
+	// Cost returns the cost of a value stored without an explicit cost,
+	// see SetWithCost. Zero means every entry costs 1.
+	Cost func(value V) int64
 }
 
 // fnv32 函数实现了 FNV-1a 哈希算法的 32 位版本
@@ -43,72 +98,416 @@ func strfnv32[K fmt.Stringer](key K) uint32 {
 	return fnv32(key.String())
 }
 
-func create[K comparable, V any](sharding ShardingFunc[K, V]) ConcurrentMap[K, V] {
+// shardCount rounds n up to a power of two, falling back to SHARD_COUNT.
+func shardCount(n int) int {
+	if n <= 0 {
+		n = SHARD_COUNT
+	}
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+func create[K comparable, V any](opts Options[K, V]) ConcurrentMap[K, V] {
+	if opts.Seed == (maphash.Seed{}) {
+		opts.Seed = maphash.MakeSeed()
+	}
+	if opts.Sharding == nil {
+		opts.Sharding = defaultSharding[K, V](opts.Seed)
+	}
 	m := ConcurrentMap[K, V]{
-		sharding: sharding,
-		shards:   make([]*SafeMap[K, V], SHARD_COUNT),
+		sharding: opts.Sharding,
+		skew:     newSkewDetector(opts.SkewFactor, opts.OnSkew),
+		state: &mapState[K, V]{
+			capacity:   opts.ShardCapacity,
+			growAt:     opts.GrowThreshold,
+			clock:      opts.Clock,
+			janitor:    janitor{interval: opts.JanitorInterval, done: make(chan struct{})},
+			maxEntries: opts.MaxEntries,
+			admission:  opts.Admission,
+			maxCost:    opts.MaxCost,
+			costOf:     opts.Cost,
+		},
 	}
-	for i := 0; i < SHARD_COUNT; i++ {
-		m.shards[i] = NewSafe[K, V]()
+	if opts.Admission == TinyLFU {
+		m.state.sketchHash = comparableHash[K](maphash.MakeSeed())
 	}
+	m.state.table.Store(m.newTable(m.state.fitShards(shardCount(opts.ShardCount))))
 	return m
 }
 
 // Creates a new concurrent map.
+// Keys are sharded by a hasher seeded randomly for every map.
 func New[V any]() ConcurrentMap[string, V] {
-	return create[string, V](fnv32)
+	return create(Options[string, V]{})
 }
 
 // Creates a new concurrent map.
+// Keys are sharded by a seeded hash of their String() value.
 func NewStringer[K Stringer, V any]() ConcurrentMap[K, V] {
-	return create[K, V](strfnv32[K])
+	seed := maphash.MakeSeed()
+	return create(Options[K, V]{Seed: seed, Sharding: stringerHash[K](seed)})
 }
 
 // Creates a new concurrent map.
 func NewWithCustom[K comparable, V any](sharding ShardingFunc[K, V]) ConcurrentMap[K, V] {
-	return create(sharding)
+	return create(Options[K, V]{Sharding: sharding})
 }
 
-// GetShard returns shard under given key
+// NewComparable creates a new concurrent map for any comparable key type,
+// sharded by the built-in seeded hasher.
+func NewComparable[K comparable, V any]() ConcurrentMap[K, V] {
+	return create(Options[K, V]{})
+}
+
+// NewWithOptions creates a new concurrent map configured by opts.
+// The shard count belongs to the map, so maps of different sizes can coexist.
+func NewWithOptions[K comparable, V any](opts Options[K, V]) ConcurrentMap[K, V] {
+	return create(opts)
+}
+
+// ShardCount returns the number of shards of the map.
+func (m ConcurrentMap[K, V]) ShardCount() int {
+	return len(m.state.table.Load().shards)
+}
+
+// GetShard returns shard under given key.
+// A shard retired by Reshard keeps its last contents for reading, but
+// writing to it panics, since the write would be lost. The shard of a
+// key changes whenever Reshard runs, including the background growth
+// enabled by Options.GrowThreshold, so a map using either should only be
+// written through its own methods, not through GetShard.
 func (m ConcurrentMap[K, V]) GetShard(key K) *SafeMap[K, V] {
-	return m.shards[uint(m.sharding(key))%uint(SHARD_COUNT)]
+	_, shard := m.locate(m.sharding(key))
+	return shard
+}
+
+// update runs fn with the write lock of the key's shard held,
+// then runs the checks that watch shard growth.
+func (m ConcurrentMap[K, V]) update(key K, fn func(s *SafeMap[K, V])) {
+	h := m.sharding(key)
+	for {
+		t, shard := m.locate(h)
+		var before, after int
+		ok := shard.tryUpdate(func(s *SafeMap[K, V]) {
+			before = len(s.m)
+			fn(s)
+			after = len(s.m)
+		})
+		if !ok {
+			// the shard was retired by a concurrent Reshard
+			continue
+		}
+		if after > before {
+			m.grown(t, int(h&t.mask), before, after)
+		}
+		return
+	}
+}
+
+// grown runs the checks that watch shard growth after a shard of t grew.
+func (m ConcurrentMap[K, V]) grown(t *shardTable[K, V], i, before, after int) {
+	if m.skew != nil && m.skew.due(before, after) {
+		m.skew.check(i, after, m.shardCounts)
+	}
+	if m.state.growAt > 0 && after > m.state.growAt {
+		m.grow(t)
+	}
+}
+
+// shardCounts returns the number of elements and the number of shards.
+func (m ConcurrentMap[K, V]) shardCounts() (total, shards int) {
+	return m.Count(), m.ShardCount()
+}
+
+// indexPool holds the buffers groupByShard sorts positions in, so that
+// batch operations do not allocate one on every call.
+var indexPool = sync.Pool{New: func() any { return new([]uint32) }}
+
+// groupByShard orders the positions of items by the shard of their key in t,
+// migrating the old shards of t first. The positions of the items of shard i
+// are order[bounds[i]:bounds[i+1]], so items is neither copied nor reordered.
+// order is carved out of buf, which is grown if needed.
+func groupByShard[K comparable, V any, T any](m ConcurrentMap[K, V], t *shardTable[K, V], items []T, key func(T) K, buf *[]uint32) (order []uint32, bounds []int) {
+	if cap(*buf) < 2*len(items) {
+		*buf = make([]uint32, 2*len(items))
+	}
+	// index holds the shard of every item, order the positions sorted by shard
+	index, order := (*buf)[:len(items)], (*buf)[len(items):2*len(items)]
+	bounds = make([]int, len(t.shards)+1)
+	for n, item := range items {
+		h := m.sharding(key(item))
+		m.migrate(t, h)
+		index[n] = h & t.mask
+		bounds[index[n]+1]++
+	}
+	for i := 1; i < len(bounds); i++ {
+		bounds[i] += bounds[i-1]
+	}
+	// fill every group from its start, which leaves bounds[i] at the start of group i+1
+	for n, i := range index {
+		order[bounds[i]] = uint32(n)
+		bounds[i]++
+	}
+	copy(bounds[1:], bounds[:len(t.shards)])
+	bounds[0] = 0
+	return order, bounds
+}
+
+// updateEach calls fn for every item, taking the write lock of one shard at
+// a time, and each only once.
+func updateEach[K comparable, V any, T any](m ConcurrentMap[K, V], items []T, key func(T) K, fn func(s *SafeMap[K, V], item T)) {
+	buf := indexPool.Get().(*[]uint32)
+	defer indexPool.Put(buf)
+	for len(items) > 0 {
+		t := m.state.table.Load()
+		order, bounds := groupByShard(m, t, items, key, buf)
+		var retry []T
+		for i, shard := range t.shards {
+			group := order[bounds[i]:bounds[i+1]]
+			if len(group) == 0 {
+				continue
+			}
+			var before, after int
+			ok := shard.tryUpdate(func(s *SafeMap[K, V]) {
+				before = len(s.m)
+				for _, n := range group {
+					fn(s, items[n])
+				}
+				after = len(s.m)
+			})
+			if !ok {
+				// the shard was retired by a concurrent Reshard, retry its items
+				for _, n := range group {
+					retry = append(retry, items[n])
+				}
+				continue
+			}
+			if after > before {
+				m.grown(t, i, before, after)
+			}
+		}
+		items = retry
+	}
+}
+
+// viewEach calls fn for every key, taking the read lock of each shard only once.
+func (m ConcurrentMap[K, V]) viewEach(keys []K, fn func(s *SafeMap[K, V], key K)) {
+	buf := indexPool.Get().(*[]uint32)
+	defer indexPool.Put(buf)
+	t := m.state.table.Load()
+	order, bounds := groupByShard(m, t, keys, identity[K], buf)
+	for i, shard := range t.shards {
+		group := order[bounds[i]:bounds[i+1]]
+		if len(group) == 0 {
+			continue
+		}
+		shard.view(func(s *SafeMap[K, V]) {
+			for _, n := range group {
+				fn(s, keys[n])
+			}
+		})
+	}
+}
+
+func identity[K any](key K) K {
+	return key
 }
 
+func tupleKey[K comparable, V any](item Tuple[K, V]) K {
+	return item.Key
+}
+
+// msetGroupMin is the average number of keys per shard from which MSet
+// groups the keys by shard instead of setting them one by one, since below
+// it the grouping costs more than the locks it saves.
+const msetGroupMin = 2
+
+// MSet sets all the given key-value pairs, taking the lock of one shard at
+// a time, and each only once.
 func (m ConcurrentMap[K, V]) MSet(data map[K]V) {
+	if len(data) < msetGroupMin*m.ShardCount() {
+		for key, value := range data {
+			m.Set(key, value)
+		}
+		return
+	}
+	buf, _ := m.state.batches.Get().(*[]Tuple[K, V])
+	if buf == nil {
+		buf = new([]Tuple[K, V])
+	}
+	items := (*buf)[:0]
 	for key, value := range data {
-		shard := m.GetShard(key)
-		shard.Set(key, value)
+		items = append(items, Tuple[K, V]{key, value})
 	}
+	updateEach(m, items, tupleKey[K, V], func(s *SafeMap[K, V], item Tuple[K, V]) {
+		s.store(item.Key, item.Val)
+	})
+	// drop the references to the pairs before reusing the buffer
+	clear(items)
+	*buf = items[:0]
+	m.state.batches.Put(buf)
+}
+
+// MGet retrieves the elements under the given keys, taking the read lock
+// of each shard only once. Missing keys are left out of the result.
+func (m ConcurrentMap[K, V]) MGet(keys []K) map[K]V {
+	result := make(map[K]V, len(keys))
+	m.viewEach(keys, func(s *SafeMap[K, V], key K) {
+		if v, ok := s.load(key); ok {
+			result[key] = v
+		}
+	})
+	return result
+}
+
+// MRemove removes the elements under the given keys, taking the lock of each shard only once.
+func (m ConcurrentMap[K, V]) MRemove(keys []K) {
+	updateEach(m, keys, identity[K], func(s *SafeMap[K, V], key K) {
+		s.delete(key)
+	})
+}
+
+// MPop removes the elements under the given keys and returns them.
+// Missing keys are left out of the result.
+func (m ConcurrentMap[K, V]) MPop(keys []K) map[K]V {
+	result := make(map[K]V, len(keys))
+	updateEach(m, keys, identity[K], func(s *SafeMap[K, V], key K) {
+		if v, ok := s.delete(key); ok {
+			result[key] = v
+		}
+	})
+	return result
 }
 
 // Sets the given value under the specified key.
 func (m ConcurrentMap[K, V]) Set(key K, value V) {
-	// Get map shard.
-	shard := m.GetShard(key)
-	shard.Set(key, value)
+	m.update(key, func(s *SafeMap[K, V]) {
+		s.store(key, value)
+	})
+}
+
+// Swap sets the value under key and returns the previous value, if any.
+// The loaded result reports whether the key was present.
+func (m ConcurrentMap[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	m.update(key, func(s *SafeMap[K, V]) {
+		previous, loaded = s.load(key)
+		s.store(key, value)
+	})
+	return
+}
+
+// LoadOrStore returns the existing value under key if present.
+// Otherwise, it stores and returns the given value.
+// The loaded result is true if the value was loaded, false if stored.
+func (m ConcurrentMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	if actual, loaded = m.Get(key); loaded {
+		return
+	}
+	m.update(key, func(s *SafeMap[K, V]) {
+		if actual, loaded = s.load(key); !loaded {
+			actual = value
+			s.store(key, value)
+		}
+	})
+	return
+}
+
+// SetWithCost sets the given value under the specified key, charging
+// cost against Options.MaxCost instead of the cost given by Options.Cost.
+// The cost is ignored by maps without a bound.
+func (m ConcurrentMap[K, V]) SetWithCost(key K, value V, cost int64) {
+	m.update(key, func(s *SafeMap[K, V]) {
+		s.put(key, value, cost, 0)
+	})
 }
 
 type UpsertCb[V any] func(oldValue V, exist bool) V
 
 // Insert or Update - updates existing element or inserts a new one using UpsertCb
 func (m ConcurrentMap[K, V]) Upsert(key K, cb UpsertCb[V]) (result V) {
-	shard := m.GetShard(key)
-	shard.Update(func(m map[K]V) {
-		v, exist := m[key]
+	m.update(key, func(s *SafeMap[K, V]) {
+		v, exist := s.load(key)
 		result = cb(v, exist)
-		m[key] = result
+		s.store(key, result)
+	})
+	return
+}
+
+// ComputeOp tells Compute what to do with the key after its callback returns.
+type ComputeOp int
+
+const (
+	// ComputeKeep leaves the key as it is, ignoring the returned value.
+	ComputeKeep ComputeOp = iota
+	// ComputeStore stores the returned value under the key.
+	ComputeStore
+	// ComputeDelete removes the key.
+	ComputeDelete
+)
+
+// ComputeCb is a callback executed in a map.Compute() call, while Lock is held.
+type ComputeCb[V any] func(oldValue V, exists bool) (newValue V, op ComputeOp)
+
+// Compute inserts, updates or deletes the element under key depending on
+// the op returned by cb, which is called with the current value while the
+// shard lock is held. It returns the value left in the map and whether
+// the key is present.
+func (m ConcurrentMap[K, V]) Compute(key K, cb ComputeCb[V]) (actual V, ok bool) {
+	m.update(key, func(s *SafeMap[K, V]) {
+		v, exist := s.load(key)
+		newValue, op := cb(v, exist)
+		switch op {
+		case ComputeStore:
+			s.store(key, newValue)
+			actual, ok = newValue, true
+		case ComputeDelete:
+			s.delete(key)
+		default:
+			actual, ok = v, exist
+		}
 	})
 	return
 }
 
+// ComputeIfAbsent returns the value under key if present. Otherwise it calls
+// cb while the shard lock is held and stores the returned value if store is
+// true. It returns the value left in the map and whether the key is present.
+func (m ConcurrentMap[K, V]) ComputeIfAbsent(key K, cb func() (value V, store bool)) (V, bool) {
+	return m.Compute(key, func(oldValue V, exists bool) (V, ComputeOp) {
+		if exists {
+			return oldValue, ComputeKeep
+		}
+		if v, store := cb(); store {
+			return v, ComputeStore
+		}
+		return oldValue, ComputeKeep
+	})
+}
+
+// ComputeIfPresent calls cb with the value under key, if present, while the
+// shard lock is held. The returned value is stored if keep is true, otherwise
+// the key is removed. It returns the value left in the map and whether the
+// key is present.
+func (m ConcurrentMap[K, V]) ComputeIfPresent(key K, cb func(oldValue V) (newValue V, keep bool)) (V, bool) {
+	return m.Compute(key, func(oldValue V, exists bool) (V, ComputeOp) {
+		if !exists {
+			return oldValue, ComputeKeep
+		}
+		if v, keep := cb(oldValue); keep {
+			return v, ComputeStore
+		}
+		return oldValue, ComputeDelete
+	})
+}
+
 // Sets the given value under the specified key if no value was associated with it.
 func (m ConcurrentMap[K, V]) SetIfAbsent(key K, value V) (ok bool) {
-	// Get map shard.
-	shard := m.GetShard(key)
-	shard.Update(func(m map[K]V) {
-		_, ok = m[key]
+	m.update(key, func(s *SafeMap[K, V]) {
+		_, ok = s.load(key)
 		if !ok {
-			m[key] = value
+			s.store(key, value)
 		}
 	})
 	return !ok
@@ -116,17 +515,57 @@ func (m ConcurrentMap[K, V]) SetIfAbsent(key K, value V) (ok bool) {
 
 // Sets the given value under the specified key if a value was associated with it.
 func (m ConcurrentMap[K, V]) SetIfExists(key K, value V) (ok bool) {
-	// Get map shard.
-	shard := m.GetShard(key)
-	shard.Update(func(m map[K]V) {
-		_, ok = m[key]
+	m.update(key, func(s *SafeMap[K, V]) {
+		_, ok = s.load(key)
 		if ok {
-			m[key] = value
+			s.store(key, value)
 		}
 	})
 	return ok
 }
 
+// CompareAndSwap swaps the old and new values for key
+// if the value stored in the map is equal to old.
+// As with sync.Map, the values must be of a comparable type.
+func (m ConcurrentMap[K, V]) CompareAndSwap(key K, old, new V) (swapped bool) {
+	return m.CompareAndSwapFunc(key, old, new, equal[V])
+}
+
+// CompareAndSwapFunc is like CompareAndSwap, but compares values with eq.
+func (m ConcurrentMap[K, V]) CompareAndSwapFunc(key K, old, new V, eq func(a, b V) bool) (swapped bool) {
+	m.update(key, func(s *SafeMap[K, V]) {
+		v, ok := s.load(key)
+		swapped = ok && eq(v, old)
+		if swapped {
+			s.store(key, new)
+		}
+	})
+	return
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old.
+// As with sync.Map, the values must be of a comparable type.
+func (m ConcurrentMap[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	return m.CompareAndDeleteFunc(key, old, equal[V])
+}
+
+// CompareAndDeleteFunc is like CompareAndDelete, but compares values with eq.
+func (m ConcurrentMap[K, V]) CompareAndDeleteFunc(key K, old V, eq func(a, b V) bool) (deleted bool) {
+	m.update(key, func(s *SafeMap[K, V]) {
+		v, ok := s.load(key)
+		deleted = ok && eq(v, old)
+		if deleted {
+			s.delete(key)
+		}
+	})
+	return
+}
+
+// equal compares two values with ==, panicking if they are not comparable.
+func equal[V any](a, b V) bool {
+	return any(a) == any(b)
+}
+
 // Get retrieves an element from map under given key.
 func (m ConcurrentMap[K, V]) Get(key K) (V, bool) {
 	// Get shard
@@ -146,13 +585,13 @@ func (m ConcurrentMap[K, V]) GetOrInsert(key K, cb InsertCb[V]) V {
 		return v
 	}
 	// update
-	shard.Update(func(m map[K]V) {
-		v, exist = m[key]
+	m.update(key, func(s *SafeMap[K, V]) {
+		v, exist = s.load(key)
 		if exist {
 			return
 		}
 		v = cb()
-		m[key] = v
+		s.store(key, v)
 	})
 	return v
 }
@@ -171,26 +610,28 @@ func (m ConcurrentMap[K, V]) GetCb(key K, cb GetCb[V]) {
 // Count returns the number of elements within the map.
 func (m ConcurrentMap[K, V]) Count() int {
 	count := 0
-	for i := 0; i < SHARD_COUNT; i++ {
-		shard := m.shards[i]
+	for _, shard := range m.settle().shards {
 		count += shard.Count()
 	}
 	return count
 }
 
-// Looks up an item under specified key
-func (m ConcurrentMap[K, V]) Has(key K) bool {
+// Looks up an item under specified key.
+// Unlike Get, it does not count as a use of the element in a bounded map.
+func (m ConcurrentMap[K, V]) Has(key K) (ok bool) {
 	// Get shard
 	shard := m.GetShard(key)
-	_, ok := shard.Get(key)
-	return ok
+	shard.view(func(s *SafeMap[K, V]) {
+		_, ok = s.load(key)
+	})
+	return
 }
 
 // Remove removes an element from the map.
 func (m ConcurrentMap[K, V]) Remove(key K) {
-	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Del(key)
+	m.update(key, func(s *SafeMap[K, V]) {
+		s.delete(key)
+	})
 }
 
 // RemoveCb is a callback executed in a map.RemoveCb() call, while Lock is held
@@ -201,14 +642,12 @@ type RemoveCb[K any, V any] func(value V, exists bool) bool
 // If callback returns true and element exists, it will remove it from the map
 // Returns the value returned by the callback (even if element was not present in the map)
 func (m ConcurrentMap[K, V]) RemoveCb(key K, cb RemoveCb[K, V]) (ok bool) {
-	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Update(func(m map[K]V) {
-		v, exist := m[key]
+	m.update(key, func(s *SafeMap[K, V]) {
+		v, exist := s.load(key)
 		result := cb(v, exist)
 		ok = exist && result
 		if ok {
-			delete(m, key)
+			s.delete(key)
 		}
 	})
 	return
@@ -216,15 +655,42 @@ func (m ConcurrentMap[K, V]) RemoveCb(key K, cb RemoveCb[K, V]) (ok bool) {
 
 // Pop removes an element from the map and returns it
 func (m ConcurrentMap[K, V]) Pop(key K) (value V, exists bool) {
-	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Update(func(m map[K]V) {
-		value, exists = m[key]
-		delete(m, key)
+	m.update(key, func(s *SafeMap[K, V]) {
+		value, exists = s.delete(key)
+	})
+	return
+}
+
+// updateAll runs fn on every shard with its write lock held. If a concurrent
+// Reshard retires the table meanwhile, fn runs again on every shard of the new one.
+func (m ConcurrentMap[K, V]) updateAll(fn func(s *SafeMap[K, V])) {
+	t := m.settle()
+	for i := 0; i < len(t.shards); i++ {
+		if !t.shards[i].tryUpdate(fn) {
+			t, i = m.settle(), -1
+		}
+	}
+}
+
+// RemoveIf removes every element for which pred returns true and returns
+// the number of removed elements. pred runs with the write lock of the
+// element's shard held, so an element can not change between the check
+// and its removal.
+func (m ConcurrentMap[K, V]) RemoveIf(pred func(key K, value V) bool) (removed int) {
+	m.updateAll(func(s *SafeMap[K, V]) {
+		removed += s.removeIf(pred)
 	})
 	return
 }
 
+// Retain keeps only the elements for which pred returns true and returns
+// the number of removed elements, see RemoveIf.
+func (m ConcurrentMap[K, V]) Retain(pred func(key K, value V) bool) int {
+	return m.RemoveIf(func(k K, v V) bool {
+		return !pred(k, v)
+	})
+}
+
 // IsEmpty checks if map is empty.
 func (m ConcurrentMap[K, V]) IsEmpty() bool {
 	return m.Count() == 0
@@ -239,34 +705,69 @@ type Tuple[K comparable, V any] struct {
 // IterBuffered returns a Iter iterator which could be used in a for range loop.
 func (m ConcurrentMap[K, V]) IterBuffered() <-chan Tuple[K, V] {
 	ch := make(chan Tuple[K, V], 1e3)
-	go fanIn(m.snapshot(), ch)
+	go fanIn(context.Background(), m.snapshot(), ch)
+	return ch
+}
+
+// IterContext is like IterBuffered, but once ctx is done it stops producing
+// items and closes the channel, so a consumer that stops reading early only
+// has to cancel ctx to release the goroutines feeding the channel.
+func (m ConcurrentMap[K, V]) IterContext(ctx context.Context) <-chan Tuple[K, V] {
+	ch := make(chan Tuple[K, V], 1e3)
+	go fanIn(ctx, m.snapshot(), ch)
 	return ch
 }
 
 // Clear removes all items from map.
+// Every shard gets a fresh internal map, so the memory held by the old
+// ones is released instead of kept at its peak size.
 func (m ConcurrentMap[K, V]) Clear() {
-	for item := range m.IterBuffered() {
-		m.Remove(item.Key)
+	m.ClearWithCallback(nil)
+}
+
+// ClearWithCallback removes all items from map like Clear, then calls fn
+// for every removed item. fn runs after the shard locks are released, so
+// it may safely use the map.
+func (m ConcurrentMap[K, V]) ClearWithCallback(fn func(key K, value V)) {
+	t := m.settle()
+	for i := 0; i < len(t.shards); i++ {
+		old, ok := t.shards[i].tryReset(m.state.capacity)
+		if !ok {
+			// a concurrent Reshard retired the table, start over on the new one
+			t, i = m.settle(), -1
+			continue
+		}
+		if fn != nil {
+			for k, v := range old {
+				fn(k, v)
+			}
+		}
 	}
 }
 
 func (m ConcurrentMap[K, V]) snapshot() []map[K]V {
-	list := make([]map[K]V, 0, SHARD_COUNT)
-	for _, shard := range m.shards {
+	shards := m.settle().shards
+	list := make([]map[K]V, 0, len(shards))
+	for _, shard := range shards {
 		list = append(list, shard.Clone())
 	}
 	return list
 }
 
-func fanIn[K comparable, V any](shards []map[K]V, ch chan Tuple[K, V]) {
+func fanIn[K comparable, V any](ctx context.Context, shards []map[K]V, ch chan Tuple[K, V]) {
 	wg := sync.WaitGroup{}
+	done := ctx.Done()
 	for _, shard := range shards {
 		wg.Add(1)
 		go func(m map[K]V) {
+			defer wg.Done()
 			for k, v := range m {
-				ch <- Tuple[K, V]{k, v}
+				select {
+				case ch <- Tuple[K, V]{k, v}:
+				case <-done:
+					return
+				}
 			}
-			wg.Done()
 		}(shard)
 	}
 	wg.Wait()
@@ -294,11 +795,75 @@ type IterCb[K comparable, V any] func(key K, v V)
 // Callback based iterator, cheapest way to read
 // all elements in a map.
 func (m ConcurrentMap[K, V]) IterCb(fn IterCb[K, V]) {
-	for _, shard := range m.shards {
+	for _, shard := range m.settle().shards {
 		shard.View(fn)
 	}
 }
 
+// Range calls fn for every key-value pair until fn returns false.
+// Like IterCb, the read lock of a shard is held while fn runs on its elements.
+func (m ConcurrentMap[K, V]) Range(fn func(key K, value V) bool) {
+	stopped := false
+	for _, shard := range m.settle().shards {
+		shard.Range(func(k K, v V) bool {
+			stopped = !fn(k, v)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// Find returns the first key-value pair satisfying pred, in no particular order.
+func (m ConcurrentMap[K, V]) Find(pred func(key K, value V) bool) (key K, value V, ok bool) {
+	m.Range(func(k K, v V) bool {
+		if pred(k, v) {
+			key, value, ok = k, v, true
+		}
+		return !ok
+	})
+	return
+}
+
+// All returns an iterator over all key-value pairs, for use in a for range loop.
+// Shards are copied one at a time and no lock is held while the loop body
+// runs, so the body may modify the map. Breaking out of the loop stops the
+// iteration without leaving anything running.
+func (m ConcurrentMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, shard := range m.settle().shards {
+			for k, v := range shard.Clone() {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// KeysSeq returns an iterator over all keys, see All.
+func (m ConcurrentMap[K, V]) KeysSeq() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// ValuesSeq returns an iterator over all values, see All.
+func (m ConcurrentMap[K, V]) ValuesSeq() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range m.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
 // Keys returns all keys as []K
 func (m ConcurrentMap[K, V]) Keys() []K {
 	// Generate keys
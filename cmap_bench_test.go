@@ -67,8 +67,8 @@ func BenchmarkSingleInsertPresentSyncMap(b *testing.B) {
 	}
 }
 
-func benchmarkMultiInsertDifferent(b *testing.B) {
-	m := New[string]()
+func benchmarkMultiInsertDifferent(b *testing.B, shardCount int) {
+	m := NewWithOptions(Options[string, string]{ShardCount: shardCount})
 	finished := make(chan struct{}, b.N)
 	_, set := GetSet(m, finished)
 	b.ResetTimer()
@@ -163,8 +163,8 @@ func BenchmarkMultiGetSameSyncMap(b *testing.B) {
 	}
 }
 
-func benchmarkMultiGetSetDifferent(b *testing.B) {
-	m := New[string]()
+func benchmarkMultiGetSetDifferent(b *testing.B, shardCount int) {
+	m := NewWithOptions(Options[string, string]{ShardCount: shardCount})
 	finished := make(chan struct{}, 2*b.N)
 	get, set := GetSet(m, finished)
 	m.Set("-1", "value")
@@ -206,8 +206,8 @@ func BenchmarkMultiGetSetDifferent_256_Shard(b *testing.B) {
 	runWithShards(benchmarkMultiGetSetDifferent, b, 256)
 }
 
-func benchmarkMultiGetSetBlock(b *testing.B) {
-	m := New[string]()
+func benchmarkMultiGetSetBlock(b *testing.B, shardCount int) {
+	m := NewWithOptions(Options[string, string]{ShardCount: shardCount})
 	finished := make(chan struct{}, 2*b.N)
 	get, set := GetSet(m, finished)
 	for i := 0; i < b.N; i++ {
@@ -283,11 +283,8 @@ func GetSetSyncMap[K comparable, V any](m *sync.Map, finished chan struct{}) (ge
 	return
 }
 
-func runWithShards(bench func(b *testing.B), b *testing.B, shardsCount int) {
-	oldShardsCount := SHARD_COUNT
-	SHARD_COUNT = shardsCount
-	bench(b)
-	SHARD_COUNT = oldShardsCount
+func runWithShards(bench func(b *testing.B, shardCount int), b *testing.B, shardsCount int) {
+	bench(b, shardsCount)
 }
 
 func BenchmarkKeys(b *testing.B) {
@@ -552,3 +549,203 @@ func BenchmarkUnmarshalJSON(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkComparableHash 测试内置哈希与Stringer哈希的性能
+func BenchmarkComparableHash(b *testing.B) {
+	b.Run("int", func(b *testing.B) {
+		m := NewComparable[int, string]()
+		for i := 0; i < b.N; i++ {
+			m.Set(i%1000, "value")
+		}
+	})
+
+	b.Run("stringer", func(b *testing.B) {
+		m := NewStringer[Integer, string]()
+		for i := 0; i < b.N; i++ {
+			m.Set(Integer(i%1000), "value")
+		}
+	})
+
+	b.Run("struct", func(b *testing.B) {
+		type point struct {
+			x, y int
+		}
+		m := NewComparable[point, string]()
+		for i := 0; i < b.N; i++ {
+			m.Set(point{i % 1000, i % 7}, "value")
+		}
+	})
+}
+
+// BenchmarkMSet 测试按分片分组的MSet与逐个Set的性能
+func BenchmarkMSet(b *testing.B) {
+	data := make(map[string]string, 1000)
+	for i := 0; i < 1000; i++ {
+		data["key"+strconv.Itoa(i)] = "value"
+	}
+
+	b.Run("grouped", func(b *testing.B) {
+		m := New[string]()
+		for i := 0; i < b.N; i++ {
+			m.MSet(data)
+		}
+	})
+
+	b.Run("per_key", func(b *testing.B) {
+		m := New[string]()
+		for i := 0; i < b.N; i++ {
+			for key, value := range data {
+				m.Set(key, value)
+			}
+		}
+	})
+
+	// 并发写入时锁竞争更明显
+	b.Run("grouped_parallel", func(b *testing.B) {
+		m := New[string]()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				m.MSet(data)
+			}
+		})
+	})
+
+	b.Run("per_key_parallel", func(b *testing.B) {
+		m := New[string]()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				for key, value := range data {
+					m.Set(key, value)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkScan 测试分批扫描整个map与一次性取出所有元素的性能
+func BenchmarkScan(b *testing.B) {
+	m := New[int]()
+	for i := 0; i < 200000; i++ {
+		m.Set("key"+strconv.Itoa(i), i)
+	}
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var cursor uint64
+			for {
+				next, _ := m.Scan(cursor, 100)
+				if next == 0 {
+					break
+				}
+				cursor = next
+			}
+		}
+	})
+
+	b.Run("items", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Items()
+		}
+	})
+}
+
+// BenchmarkMGet 测试按分片分组的MGet与逐个Get的性能
+func BenchmarkMGet(b *testing.B) {
+	m := New[string]()
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		m.Set(key, "value")
+		keys = append(keys, key)
+	}
+
+	b.Run("grouped", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.MGet(keys)
+		}
+	})
+
+	b.Run("per_key", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			result := make(map[string]string, len(keys))
+			for _, key := range keys {
+				if v, ok := m.Get(key); ok {
+					result[key] = v
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkMRemove 测试按分片分组的MRemove与逐个Remove的性能
+func BenchmarkMRemove(b *testing.B) {
+	data := make(map[string]string, 1000)
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		data[key] = "value"
+		keys = append(keys, key)
+	}
+
+	b.Run("grouped", func(b *testing.B) {
+		m := New[string]()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			m.MSet(data)
+			b.StartTimer()
+			m.MRemove(keys)
+		}
+	})
+
+	b.Run("per_key", func(b *testing.B) {
+		m := New[string]()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			m.MSet(data)
+			b.StartTimer()
+			for _, key := range keys {
+				m.Remove(key)
+			}
+		}
+	})
+}
+
+// BenchmarkBounded 对比有上限和无上限的 map 的读写性能
+func BenchmarkBounded(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	for _, bench := range []struct {
+		name string
+		opts Options[string, int]
+	}{
+		{"unbounded", Options[string, int]{}},
+		{"lru", Options[string, int]{MaxEntries: 5000}},
+		{"tinylfu", Options[string, int]{MaxEntries: 5000, Admission: TinyLFU}},
+	} {
+		b.Run(bench.name+"/set", func(b *testing.B) {
+			m := NewWithOptions(bench.opts)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Set(keys[i%len(keys)], i)
+			}
+		})
+
+		b.Run(bench.name+"/get", func(b *testing.B) {
+			m := NewWithOptions(bench.opts)
+			for i, key := range keys {
+				m.Set(key, i)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					m.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
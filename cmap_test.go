@@ -1,13 +1,16 @@
 package cmap
 
 import (
+	"context"
 	"encoding/json"
 	"hash/fnv"
+	"runtime"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Animal struct {
@@ -21,7 +24,7 @@ func (a Animal) String() string {
 
 func TestMapCreation(t *testing.T) {
 	m := New[string]()
-	if m.shards == nil {
+	if m.state == nil {
 		t.Error("map is null.")
 	}
 
@@ -456,7 +459,7 @@ func TestFnv32(t *testing.T) {
 	hasher := fnv.New32()
 	_, err := hasher.Write(key)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 	if fnv32(string(key)) != hasher.Sum32() {
 		t.Errorf("Bundled fnv32 produced %d, expected result from hash/fnv32 is %d", fnv32(string(key)), hasher.Sum32())
@@ -790,7 +793,7 @@ func TestEmptyMapJson(t *testing.T) {
 	}
 }
 
-// 测试MGet功能（如果实现了的话）
+// 测试MGet功能
 func TestMGet(t *testing.T) {
 	m := New[string]()
 
@@ -801,13 +804,7 @@ func TestMGet(t *testing.T) {
 
 	// 测试是否能正确获取多个键值对
 	keys := []string{"key1", "key2", "key4"}
-	results := make(map[string]string)
-
-	for _, key := range keys {
-		if val, ok := m.Get(key); ok {
-			results[key] = val
-		}
-	}
+	results := m.MGet(keys)
 
 	if len(results) != 2 {
 		t.Error("应该只找到2个键")
@@ -912,7 +909,7 @@ func TestStrfnv32(t *testing.T) {
 // 测试使用Stringer接口创建ConcurrentMap
 func TestNewStringer(t *testing.T) {
 	m := NewStringer[Animal, int]()
-	if m.shards == nil {
+	if m.state == nil {
 		t.Error("map不应为null")
 	}
 
@@ -938,7 +935,7 @@ func TestNewWithCustomShardingFunction(t *testing.T) {
 	}
 
 	m := NewWithCustom[string, int](customShardingFunc)
-	if m.shards == nil {
+	if m.state == nil {
 		t.Error("map不应为null")
 	}
 
@@ -1183,3 +1180,646 @@ func TestGetOrInsertCoverage(t *testing.T) {
 		t.Errorf("对于新键，应该返回回调函数的值 %s，而不是 %s", "new_value", newValue)
 	}
 }
+
+// 测试通过选项创建map
+func TestNewWithOptions(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 5, ShardCapacity: 16})
+	if m.ShardCount() != 8 {
+		t.Errorf("分片数应向上取整为8，实际为 %d", m.ShardCount())
+	}
+
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if m.Count() != 100 {
+		t.Error("map应该包含100个元素")
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Errorf("键 %d 的值不正确", i)
+		}
+	}
+
+	// 修改SHARD_COUNT不影响已有的map
+	originalShardCount := SHARD_COUNT
+	SHARD_COUNT = 2
+	defer func() {
+		SHARD_COUNT = originalShardCount
+	}()
+	small := New[int]()
+	if small.ShardCount() != 2 || m.ShardCount() != 8 {
+		t.Error("不同分片数的map应互不影响")
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Errorf("修改SHARD_COUNT后键 %d 的值不正确", i)
+		}
+	}
+}
+
+// 测试分片数取整
+func TestShardCountRounding(t *testing.T) {
+	tests := []struct {
+		in   int
+		want int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{32, 32},
+		{33, 64},
+	}
+	for _, tt := range tests {
+		if got := shardCount(tt.in); got != tt.want {
+			t.Errorf("shardCount(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+	if got := shardCount(0); got != SHARD_COUNT {
+		t.Errorf("shardCount(0) = %d, want %d", got, SHARD_COUNT)
+	}
+}
+
+// 测试任意可比较类型作为键
+func TestNewComparable(t *testing.T) {
+	type point struct {
+		x, y int
+	}
+
+	m := NewComparable[point, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(point{i, -i}, i)
+	}
+	if m.Count() != 100 {
+		t.Error("map应该包含100个元素")
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Get(point{i, -i}); !ok || v != i {
+			t.Errorf("键 %d 的值不正确", i)
+		}
+	}
+
+	ints := NewWithOptions(Options[int, string]{})
+	ints.Set(42, "answer")
+	if v, ok := ints.Get(42); !ok || v != "answer" {
+		t.Error("未提供分片函数时整数键应使用内置哈希")
+	}
+}
+
+// 测试range-over-func迭代器
+func TestAll(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]int)
+	for k, v := range m.All() {
+		seen[k] = v
+	}
+	if len(seen) != 100 {
+		t.Errorf("应该遍历100个元素，实际为 %d", len(seen))
+	}
+	for k, v := range seen {
+		if k != strconv.Itoa(v) {
+			t.Errorf("键 %s 的值 %d 不正确", k, v)
+		}
+	}
+
+	// 提前退出
+	counter := 0
+	for range m.All() {
+		counter++
+		if counter == 10 {
+			break
+		}
+	}
+	if counter != 10 {
+		t.Error("break应该停止遍历")
+	}
+
+	// 循环体中可以修改map
+	for k := range m.KeysSeq() {
+		m.Remove(k)
+	}
+	if !m.IsEmpty() {
+		t.Error("遍历时删除所有键后map应为空")
+	}
+}
+
+// 测试键和值的迭代器
+func TestKeysValuesSeq(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 10; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	keys := slices.Collect(m.KeysSeq())
+	if len(keys) != 10 {
+		t.Errorf("应该有10个键，实际为 %d", len(keys))
+	}
+
+	sum := 0
+	for v := range m.ValuesSeq() {
+		sum += v
+	}
+	if sum != 45 {
+		t.Errorf("值之和应为45，实际为 %d", sum)
+	}
+}
+
+// 测试取消context后迭代器的goroutine全部退出
+func TestIterContext(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	// 完整遍历
+	counter := 0
+	for range m.IterContext(context.Background()) {
+		counter++
+	}
+	if counter != 10000 {
+		t.Errorf("应该遍历10000个元素，实际为 %d", counter)
+	}
+
+	before := runtime.NumGoroutine()
+
+	// 只读取部分元素后取消
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := m.IterContext(ctx)
+	for i := 0; i < 10; i++ {
+		<-ch
+	}
+	cancel()
+
+	// channel最终会被关闭
+	for range ch {
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("取消后goroutine泄漏: 之前 %d 个，现在 %d 个", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 测试消费者不再读取时取消context可以释放goroutine
+func TestIterContextAbandoned(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := m.IterContext(ctx)
+	<-ch
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("取消后goroutine泄漏: 之前 %d 个，现在 %d 个", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 测试可提前终止的回调遍历
+func TestRange(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	counter := 0
+	m.Range(func(key string, v int) bool {
+		counter++
+		return true
+	})
+	if counter != 100 {
+		t.Errorf("应该遍历100个元素，实际为 %d", counter)
+	}
+
+	counter = 0
+	m.Range(func(key string, v int) bool {
+		counter++
+		return counter < 10
+	})
+	if counter != 10 {
+		t.Errorf("回调返回false后应停止遍历，实际遍历了 %d 个", counter)
+	}
+}
+
+// 测试查找第一个满足条件的元素
+func TestFind(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	visited := 0
+	key, v, ok := m.Find(func(key string, v int) bool {
+		visited++
+		return v == 42
+	})
+	if !ok || key != "42" || v != 42 {
+		t.Errorf("Find() = %s, %d, %v", key, v, ok)
+	}
+	if visited > 100 {
+		t.Error("找到后不应该继续遍历")
+	}
+
+	if _, _, ok := m.Find(func(key string, v int) bool { return v < 0 }); ok {
+		t.Error("不应该找到负数")
+	}
+}
+
+// 测试比较并交换
+func TestCompareAndSwap(t *testing.T) {
+	m := New[int]()
+
+	if m.CompareAndSwap("key", 0, 1) {
+		t.Error("键不存在时不应交换")
+	}
+	if m.Has("key") {
+		t.Error("键不存在时不应插入")
+	}
+
+	m.Set("key", 1)
+	if m.CompareAndSwap("key", 2, 3) {
+		t.Error("值不相等时不应交换")
+	}
+	if !m.CompareAndSwap("key", 1, 3) {
+		t.Error("值相等时应该交换")
+	}
+	if v, _ := m.Get("key"); v != 3 {
+		t.Errorf("交换后的值应为3，实际为 %d", v)
+	}
+}
+
+// 测试比较并删除
+func TestCompareAndDelete(t *testing.T) {
+	m := New[int]()
+
+	if m.CompareAndDelete("key", 0) {
+		t.Error("键不存在时不应删除")
+	}
+
+	m.Set("key", 1)
+	if m.CompareAndDelete("key", 2) {
+		t.Error("值不相等时不应删除")
+	}
+	if !m.CompareAndDelete("key", 1) {
+		t.Error("值相等时应该删除")
+	}
+	if m.Has("key") {
+		t.Error("键应该被删除")
+	}
+}
+
+// 测试使用自定义比较函数的版本
+func TestCompareAndSwapFunc(t *testing.T) {
+	m := New[[]int]()
+	m.Set("key", []int{1, 2})
+
+	if !m.CompareAndSwapFunc("key", []int{1, 2}, []int{3}, slices.Equal[[]int]) {
+		t.Error("切片相等时应该交换")
+	}
+	if v, _ := m.Get("key"); !slices.Equal(v, []int{3}) {
+		t.Errorf("交换后的值不正确: %v", v)
+	}
+	if m.CompareAndDeleteFunc("key", []int{1, 2}, slices.Equal[[]int]) {
+		t.Error("切片不相等时不应删除")
+	}
+	if !m.CompareAndDeleteFunc("key", []int{3}, slices.Equal[[]int]) {
+		t.Error("切片相等时应该删除")
+	}
+
+	// 不可比较的值使用 CompareAndSwap 会panic
+	m.Set("key", []int{1})
+	defer func() {
+		if recover() == nil {
+			t.Error("不可比较的值应该panic")
+		}
+	}()
+	m.CompareAndSwap("key", []int{1}, nil)
+}
+
+// 测试并发比较并交换实现的计数器
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	m := New[int]()
+	m.Set("counter", 0)
+
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				for {
+					v, _ := m.Get("counter")
+					if m.CompareAndSwap("counter", v, v+1) {
+						break
+					}
+				}
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	if v, _ := m.Get("counter"); v != 1000 {
+		t.Errorf("计数器应为1000，实际为 %d", v)
+	}
+}
+
+// 测试批量设置、删除和弹出
+func TestMSetMRemoveMPop(t *testing.T) {
+	m := New[int]()
+	data := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		data[strconv.Itoa(i)] = i
+	}
+	m.MSet(data)
+	if m.Count() != 100 {
+		t.Errorf("应有100个元素，实际为 %d", m.Count())
+	}
+
+	got := m.MGet([]string{"1", "2", "1", "missing"})
+	if len(got) != 2 || got["1"] != 1 || got["2"] != 2 {
+		t.Errorf("MGet 结果不正确: %v", got)
+	}
+
+	popped := m.MPop([]string{"3", "4", "missing"})
+	if len(popped) != 2 || popped["3"] != 3 || popped["4"] != 4 {
+		t.Errorf("MPop 结果不正确: %v", popped)
+	}
+	if m.Has("3") || m.Has("4") {
+		t.Error("MPop 后键应该被删除")
+	}
+
+	keys := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	m.MRemove(keys)
+	if m.Count() != 50 {
+		t.Errorf("MRemove 后应有50个元素，实际为 %d", m.Count())
+	}
+	for i := 50; i < 100; i++ {
+		if v, ok := m.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Errorf("键 %d 不应该被删除", i)
+		}
+	}
+}
+
+// 测试重新分片期间的批量操作
+func TestMSetWhileResharding(t *testing.T) {
+	// 小批量逐个写入，大批量按分片分组写入
+	for _, size := range []int{10, 1000} {
+		m := NewWithOptions(Options[int, int]{ShardCount: 2})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, n := range []int{16, 4, 64, 8} {
+				m.Reshard(n)
+			}
+		}()
+
+		for i := 0; i < 100; i++ {
+			data := make(map[int]int)
+			for j := 0; j < size; j++ {
+				data[i*size+j] = i
+			}
+			m.MSet(data)
+		}
+		<-done
+
+		if m.Count() != 100*size {
+			t.Errorf("应有%d个元素，实际为 %d", 100*size, m.Count())
+		}
+		for i := 0; i < 100*size; i++ {
+			if v, ok := m.Get(i); !ok || v != i/size {
+				t.Fatalf("Get(%d) = %d, %v", i, v, ok)
+			}
+		}
+	}
+}
+
+// 测试清空时回调被删除的元素
+func TestClearWithCallback(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	removed := make(map[string]int)
+	m.ClearWithCallback(func(key string, v int) {
+		removed[key] = v
+		// 回调中可以使用map
+		m.Has(key)
+	})
+
+	if m.Count() != 0 {
+		t.Error("清空后map应为空")
+	}
+	if len(removed) != 100 {
+		t.Errorf("应回调100个元素，实际为 %d", len(removed))
+	}
+	for k, v := range removed {
+		if k != strconv.Itoa(v) {
+			t.Errorf("回调的键 %s 和值 %d 不匹配", k, v)
+		}
+	}
+
+	// 清空后可以继续使用
+	m.Set("key", 1)
+	if v, ok := m.Get("key"); !ok || v != 1 {
+		t.Error("清空后应该可以继续写入")
+	}
+}
+
+// 测试重新分片期间清空
+func TestClearWhileResharding(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 2})
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Reshard(64)
+	}()
+	m.Clear()
+	<-done
+
+	if m.Count() != 0 {
+		t.Errorf("清空后map应为空，实际有 %d 个元素", m.Count())
+	}
+}
+
+// 测试按条件批量删除
+func TestRemoveIf(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	removed := m.RemoveIf(func(key string, v int) bool {
+		return v%2 == 0
+	})
+	if removed != 50 {
+		t.Errorf("应删除50个元素，实际为 %d", removed)
+	}
+	if m.Count() != 50 {
+		t.Errorf("应剩余50个元素，实际为 %d", m.Count())
+	}
+	m.IterCb(func(key string, v int) {
+		if v%2 == 0 {
+			t.Errorf("偶数 %d 应该被删除", v)
+		}
+	})
+
+	removed = m.Retain(func(key string, v int) bool {
+		return v < 10
+	})
+	if removed != 45 {
+		t.Errorf("应删除45个元素，实际为 %d", removed)
+	}
+	if m.Count() != 5 {
+		t.Errorf("应剩余5个元素，实际为 %d", m.Count())
+	}
+}
+
+// 测试重新分片期间按条件删除
+func TestRemoveIfWhileResharding(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 2})
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Reshard(64)
+	}()
+	removed := m.RemoveIf(func(key int, v int) bool {
+		return key < 500
+	})
+	<-done
+
+	if removed != 500 || m.Count() != 500 {
+		t.Errorf("应删除500个元素并剩余500个，实际删除 %d 个，剩余 %d 个", removed, m.Count())
+	}
+}
+
+// 测试Compute的插入、更新、删除和保持不变
+func TestCompute(t *testing.T) {
+	m := New[int]()
+
+	// 键不存在时插入
+	v, ok := m.Compute("key", func(old int, exists bool) (int, ComputeOp) {
+		if exists {
+			t.Error("键不应该存在")
+		}
+		return 1, ComputeStore
+	})
+	if !ok || v != 1 {
+		t.Errorf("Compute() = %d, %v", v, ok)
+	}
+
+	// 更新
+	v, ok = m.Compute("key", func(old int, exists bool) (int, ComputeOp) {
+		return old + 1, ComputeStore
+	})
+	if !ok || v != 2 {
+		t.Errorf("Compute() = %d, %v", v, ok)
+	}
+
+	// 保持不变时忽略返回值
+	v, ok = m.Compute("key", func(old int, exists bool) (int, ComputeOp) {
+		return 100, ComputeKeep
+	})
+	if !ok || v != 2 {
+		t.Errorf("Compute() = %d, %v", v, ok)
+	}
+	if stored, _ := m.Get("key"); stored != 2 {
+		t.Error("ComputeKeep 不应修改值")
+	}
+
+	// 删除
+	v, ok = m.Compute("key", func(old int, exists bool) (int, ComputeOp) {
+		return 0, ComputeDelete
+	})
+	if ok || v != 0 || m.Has("key") {
+		t.Error("ComputeDelete 应该删除键")
+	}
+
+	// 键不存在时保持不变
+	if _, ok = m.Compute("missing", func(old int, exists bool) (int, ComputeOp) {
+		return 1, ComputeKeep
+	}); ok || m.Has("missing") {
+		t.Error("ComputeKeep 不应插入键")
+	}
+}
+
+// 测试ComputeIfAbsent和ComputeIfPresent
+func TestComputeIfAbsentIfPresent(t *testing.T) {
+	m := New[int]()
+
+	if v, ok := m.ComputeIfAbsent("key", func() (int, bool) { return 1, true }); !ok || v != 1 {
+		t.Errorf("ComputeIfAbsent() = %d, %v", v, ok)
+	}
+	if v, ok := m.ComputeIfAbsent("key", func() (int, bool) {
+		t.Error("键存在时不应调用回调")
+		return 2, true
+	}); !ok || v != 1 {
+		t.Errorf("ComputeIfAbsent() = %d, %v", v, ok)
+	}
+	if _, ok := m.ComputeIfAbsent("other", func() (int, bool) { return 2, false }); ok || m.Has("other") {
+		t.Error("回调拒绝存储时不应插入键")
+	}
+
+	if v, ok := m.ComputeIfPresent("key", func(old int) (int, bool) { return old + 10, true }); !ok || v != 11 {
+		t.Errorf("ComputeIfPresent() = %d, %v", v, ok)
+	}
+	if _, ok := m.ComputeIfPresent("missing", func(old int) (int, bool) {
+		t.Error("键不存在时不应调用回调")
+		return 0, true
+	}); ok || m.Has("missing") {
+		t.Error("键不存在时不应插入")
+	}
+	if _, ok := m.ComputeIfPresent("key", func(old int) (int, bool) { return 0, false }); ok || m.Has("key") {
+		t.Error("回调返回false时应该删除键")
+	}
+}
+
+// 测试Swap和LoadOrStore，语义与sync.Map一致
+func TestSwapLoadOrStore(t *testing.T) {
+	m := New[int]()
+
+	if prev, loaded := m.Swap("key", 1); loaded || prev != 0 {
+		t.Errorf("Swap() = %d, %v", prev, loaded)
+	}
+	if prev, loaded := m.Swap("key", 2); !loaded || prev != 1 {
+		t.Errorf("Swap() = %d, %v", prev, loaded)
+	}
+	if v, _ := m.Get("key"); v != 2 {
+		t.Error("Swap 应该保存新值")
+	}
+
+	if actual, loaded := m.LoadOrStore("key", 3); !loaded || actual != 2 {
+		t.Errorf("LoadOrStore() = %d, %v", actual, loaded)
+	}
+	if actual, loaded := m.LoadOrStore("other", 4); loaded || actual != 4 {
+		t.Errorf("LoadOrStore() = %d, %v", actual, loaded)
+	}
+	if v, _ := m.Get("other"); v != 4 {
+		t.Error("LoadOrStore 应该保存新值")
+	}
+}
@@ -0,0 +1,75 @@
+package cmap
+
+import (
+	"sync/atomic"
+)
+
+// CounterMap is a sharded map of int64 counters. Every counter is an
+// atomic cell, so incrementing an existing key only takes the read lock
+// of its shard, and concurrent increments of the same key never block
+// each other.
+type CounterMap[K comparable] struct {
+	m ConcurrentMap[K, *atomic.Int64]
+}
+
+// NewCounterMap creates a new counter map for any comparable key type.
+func NewCounterMap[K comparable]() CounterMap[K] {
+	return CounterMap[K]{m: NewComparable[K, *atomic.Int64]()}
+}
+
+func newCounterCell() *atomic.Int64 {
+	return new(atomic.Int64)
+}
+
+// cell returns the counter of key, creating it if missing.
+func (c CounterMap[K]) cell(key K) *atomic.Int64 {
+	if cell, ok := c.m.Get(key); ok {
+		return cell
+	}
+	return c.m.GetOrInsert(key, newCounterCell)
+}
+
+// Add adds delta to the counter of key and returns the new value.
+// A missing counter starts at zero.
+func (c CounterMap[K]) Add(key K, delta int64) int64 {
+	return c.cell(key).Add(delta)
+}
+
+// Inc increments the counter of key and returns the new value.
+func (c CounterMap[K]) Inc(key K) int64 {
+	return c.Add(key, 1)
+}
+
+// Get returns the counter of key, zero if it was never added to.
+func (c CounterMap[K]) Get(key K) int64 {
+	if cell, ok := c.m.Get(key); ok {
+		return cell.Load()
+	}
+	return 0
+}
+
+// Reset sets the counter of key to zero and returns its previous value.
+// The key is kept, so increments racing with Reset are never lost:
+// they are either part of the returned value or of the new count.
+func (c CounterMap[K]) Reset(key K) int64 {
+	if cell, ok := c.m.Get(key); ok {
+		return cell.Swap(0)
+	}
+	return 0
+}
+
+// Count returns the number of counters within the map.
+func (c CounterMap[K]) Count() int {
+	return c.m.Count()
+}
+
+// Snapshot returns the values of all counters. Each value is read
+// atomically, but counters changing meanwhile may be read at different times.
+func (c CounterMap[K]) Snapshot() map[K]int64 {
+	result := make(map[K]int64, c.m.Count())
+	c.m.Range(func(key K, cell *atomic.Int64) bool {
+		result[key] = cell.Load()
+		return true
+	})
+	return result
+}
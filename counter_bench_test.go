@@ -0,0 +1,85 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+// BenchmarkCounter 对比 CounterMap 与使用 Upsert 实现计数的性能
+func BenchmarkCounter(b *testing.B) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.Run("CounterMap", func(b *testing.B) {
+		c := NewCounterMap[string]()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.Inc(keys[i%len(keys)])
+		}
+	})
+
+	b.Run("Upsert", func(b *testing.B) {
+		m := New[int64]()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m.Upsert(keys[i%len(keys)], func(v int64, _ bool) int64 {
+				return v + 1
+			})
+		}
+	})
+
+	b.Run("CounterMap/parallel", func(b *testing.B) {
+		c := NewCounterMap[string]()
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				c.Inc(keys[i%len(keys)])
+				i++
+			}
+		})
+	})
+
+	b.Run("Upsert/parallel", func(b *testing.B) {
+		m := New[int64]()
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				m.Upsert(keys[i%len(keys)], func(v int64, _ bool) int64 {
+					return v + 1
+				})
+				i++
+			}
+		})
+	})
+
+	// 所有 goroutine 递增同一个键
+	b.Run("CounterMap/hot_key", func(b *testing.B) {
+		c := NewCounterMap[string]()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.Inc("hot")
+			}
+		})
+	})
+
+	b.Run("Upsert/hot_key", func(b *testing.B) {
+		m := New[int64]()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				m.Upsert("hot", func(v int64, _ bool) int64 {
+					return v + 1
+				})
+			}
+		})
+	})
+}
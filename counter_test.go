@@ -0,0 +1,99 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// 测试计数器的基本操作
+func TestCounterMap(t *testing.T) {
+	c := NewCounterMap[string]()
+
+	if c.Get("a") != 0 {
+		t.Error("不存在的计数器应为0")
+	}
+	if v := c.Inc("a"); v != 1 {
+		t.Errorf("Inc() = %d", v)
+	}
+	if v := c.Add("a", 10); v != 11 {
+		t.Errorf("Add() = %d", v)
+	}
+	if v := c.Add("b", -3); v != -3 {
+		t.Errorf("Add() = %d", v)
+	}
+	if c.Get("a") != 11 || c.Count() != 2 {
+		t.Error("计数不正确")
+	}
+
+	snapshot := c.Snapshot()
+	if len(snapshot) != 2 || snapshot["a"] != 11 || snapshot["b"] != -3 {
+		t.Errorf("Snapshot() = %v", snapshot)
+	}
+
+	if v := c.Reset("a"); v != 11 {
+		t.Errorf("Reset() = %d", v)
+	}
+	if c.Get("a") != 0 || c.Reset("missing") != 0 {
+		t.Error("重置后计数器应为0")
+	}
+}
+
+// 测试并发递增不会丢失计数
+func TestCounterMapConcurrent(t *testing.T) {
+	c := NewCounterMap[string]()
+	const goroutines, increments = 8, 1000
+
+	var wg sync.WaitGroup
+	var reset sync.Mutex
+	total := int64(0)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				c.Inc(strconv.Itoa(i % 10))
+				if i%100 == 0 {
+					// 与递增并发的重置也不会丢失计数
+					v := c.Reset(strconv.Itoa(i % 10))
+					reset.Lock()
+					total += v
+					reset.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, v := range c.Snapshot() {
+		total += v
+	}
+	if total != goroutines*increments {
+		t.Errorf("计数应为 %d，实际为 %d", goroutines*increments, total)
+	}
+}
+
+// 测试扩容时递增不会丢失计数
+func TestCounterMapReshard(t *testing.T) {
+	c := NewCounterMap[int]()
+	for i := 0; i < 100; i++ {
+		c.Inc(i)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Inc(i)
+		}
+	}()
+	c.m.Reshard(128)
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		if c.Get(i) != 2 {
+			t.Fatalf("键 %d 的计数应为2，实际为 %d", i, c.Get(i))
+		}
+	}
+}
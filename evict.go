@@ -0,0 +1,100 @@
+package cmap
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// EvictReason tells why an entry left the map.
+type EvictReason int
+
+const (
+	// EvictExplicit means the entry was removed by Remove, Pop, Clear,
+	// RemoveIf or any other call removing it on purpose.
+	EvictExplicit EvictReason = iota
+	// EvictReplaced means the value was replaced by a write to its key.
+	EvictReplaced
+	// EvictExpired means the entry outlived its TTL.
+	EvictExpired
+	// EvictCapacity means the entry was evicted to respect
+	// Options.MaxEntries or Options.MaxCost.
+	EvictCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExplicit:
+		return "explicit"
+	case EvictReplaced:
+		return "replaced"
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+// EvictCb is a callback executed when an entry leaves the map.
+type EvictCb[K comparable, V any] func(key K, value V, reason EvictReason)
+
+// evicted is an entry waiting to be passed to the listeners.
+type evicted[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// evictListeners are the callbacks registered with OnEvict.
+type evictListeners[K comparable, V any] struct {
+	mu  sync.Mutex
+	cbs atomic.Pointer[[]EvictCb[K, V]]
+}
+
+func (l *evictListeners[K, V]) add(cb EvictCb[K, V]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var cbs []EvictCb[K, V]
+	if old := l.cbs.Load(); old != nil {
+		cbs = slices.Clone(*old)
+	}
+	cbs = append(cbs, cb)
+	l.cbs.Store(&cbs)
+}
+
+// active reports whether any callback is registered.
+func (l *evictListeners[K, V]) active() bool {
+	return l != nil && l.cbs.Load() != nil
+}
+
+func (l *evictListeners[K, V]) notify(pending []evicted[K, V]) {
+	cbs := *l.cbs.Load()
+	for _, e := range pending {
+		for _, cb := range cbs {
+			cb(e.key, e.value, e.reason)
+		}
+	}
+}
+
+// OnEvict registers cb to be called for every entry leaving the map:
+// removed, replaced by a write, expired or evicted for capacity. Several
+// callbacks may be registered; they are called in registration order.
+// Entries removed before cb is registered are not reported.
+//
+// cb is called after the shard lock is released, so it may use the map,
+// and by then the entry is no longer visible in it. It runs on the
+// goroutine of the operation that removed the entry, before that
+// operation returns: the janitor for entries it reclaims, and the
+// goroutine migrating a shard for the entries a Reshard drops. The
+// entries removed by one operation are reported in the order they left
+// the map, but operations running concurrently may report theirs in any
+// order, even for the same key, so cb must be safe for concurrent use.
+//
+// Expired entries are reported when they are reclaimed, which may be
+// long after they expired. Entries removed through the map passed to
+// SafeMap.Update are not reported.
+func (m ConcurrentMap[K, V]) OnEvict(cb EvictCb[K, V]) {
+	m.state.listeners.add(cb)
+}
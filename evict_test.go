@@ -0,0 +1,200 @@
+package cmap
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// evictLog 记录监听器收到的通知
+type evictLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *evictLog) add(key string, value int, reason EvictReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf("%s=%d:%s", key, value, reason))
+}
+
+func (l *evictLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+// 测试显式删除和替换的通知及其顺序
+func TestOnEvict(t *testing.T) {
+	m := New[int]()
+	var log evictLog
+	m.OnEvict(log.add)
+
+	m.Set("key", 1)
+	m.Set("key", 2)
+	m.Swap("key", 3)
+	m.Remove("key")
+	m.Remove("key")
+	want := []string{"key=1:replaced", "key=2:replaced", "key=3:explicit"}
+	if got := log.take(); !slices.Equal(got, want) {
+		t.Errorf("通知为 %v，期望 %v", got, want)
+	}
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+	m.Pop("a")
+	m.RemoveIf(func(k string, v int) bool { return v == 2 })
+	m.Clear()
+	want = []string{"a=1:explicit", "b=2:explicit", "c=3:explicit"}
+	if got := log.take(); !slices.Equal(got, want) {
+		t.Errorf("通知为 %v，期望 %v", got, want)
+	}
+
+	// LoadOrStore 没有写入时不通知
+	m.Set("d", 4)
+	m.LoadOrStore("d", 5)
+	if got := log.take(); len(got) != 0 {
+		t.Errorf("不应有通知，实际为 %v", got)
+	}
+}
+
+// 测试多个监听器按注册顺序调用
+func TestOnEvictListeners(t *testing.T) {
+	m := New[int]()
+	var order []int
+	m.OnEvict(func(string, int, EvictReason) { order = append(order, 1) })
+	m.OnEvict(func(string, int, EvictReason) { order = append(order, 2) })
+
+	m.Set("key", 1)
+	m.Remove("key")
+	if !slices.Equal(order, []int{1, 2}) {
+		t.Errorf("调用顺序为 %v", order)
+	}
+}
+
+// 测试容量淘汰按最近最少使用的顺序通知
+func TestOnEvictCapacity(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 1, MaxEntries: 2})
+	var log evictLog
+	m.OnEvict(log.add)
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Get("a")
+	m.Set("c", 3)
+	m.Set("d", 4)
+	want := []string{"b=2:capacity", "a=1:capacity"}
+	if got := log.take(); !slices.Equal(got, want) {
+		t.Errorf("通知为 %v，期望 %v", got, want)
+	}
+
+	// 缩小分片时被淘汰的元素同样会通知
+	m = NewWithOptions(Options[string, int]{ShardCount: 4, MaxEntries: 4})
+	m.OnEvict(log.add)
+	for i := 0; i < 4; i++ {
+		m.Set(fmt.Sprint(i), i)
+	}
+	m.Reshard(1)
+	if got := log.take(); len(got)+m.Count() != 4 {
+		t.Errorf("通知 %v 与剩余的 %d 个元素不符", got, m.Count())
+	}
+}
+
+// 测试过期的元素在回收时通知
+func TestOnEvictExpired(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[string, int]{Clock: clock.Now})
+	defer m.Close()
+	var log evictLog
+	m.OnEvict(log.add)
+
+	m.SetWithTTL("a", 1, time.Second)
+	m.SetWithTTL("b", 2, time.Second)
+	clock.Advance(time.Minute)
+
+	// 读取不回收过期的元素
+	if _, ok := m.Get("a"); ok || len(log.take()) != 0 {
+		t.Error("读取过期的元素不应通知")
+	}
+	// 覆盖和删除过期的元素时原因为过期
+	m.Set("a", 3)
+	m.Remove("b")
+	want := []string{"a=1:expired", "b=2:expired"}
+	if got := log.take(); !slices.Equal(got, want) {
+		t.Errorf("通知为 %v，期望 %v", got, want)
+	}
+
+	// 过期时间已过的 ExpireAt 同样以过期为原因移除元素
+	m.ExpireAt("a", clock.Now().Add(-time.Second))
+	want = []string{"a=3:expired"}
+	if got := log.take(); !slices.Equal(got, want) {
+		t.Errorf("通知为 %v，期望 %v", got, want)
+	}
+}
+
+// 测试后台回收的元素由回收协程通知
+func TestOnEvictJanitor(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[string, int]{
+		Clock:           clock.Now,
+		JanitorInterval: time.Millisecond,
+	})
+	defer m.Close()
+	reclaimed := make(chan string, 1)
+	m.OnEvict(func(key string, value int, reason EvictReason) {
+		if reason == EvictExpired {
+			reclaimed <- key
+		}
+	})
+
+	m.SetWithTTL("key", 1, time.Second)
+	clock.Advance(time.Minute)
+	select {
+	case key := <-reclaimed:
+		if key != "key" {
+			t.Errorf("回收的键为 %s", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("过期的元素没有被回收")
+	}
+}
+
+// 测试监听器在锁外调用，可以访问 map 且看不到被移除的元素
+func TestOnEvictReentrant(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{MaxEntries: 64})
+	m.OnEvict(func(key string, value int, reason EvictReason) {
+		if m.Has(key) && reason != EvictReplaced {
+			t.Errorf("监听器不应看到被移除的 %s", key)
+		}
+		if reason == EvictExplicit {
+			m.Set("removed:"+key, value)
+		}
+	})
+
+	m.Set("key", 1)
+	m.Remove("key")
+	if v, ok := m.Get("removed:key"); !ok || v != 1 {
+		t.Error("监听器应该可以写入 map")
+	}
+
+	// 事务和容量淘汰同样在解锁后通知
+	err := m.Txn([]string{"a", "b"}, func(tx *Tx[string, int]) error {
+		tx.Set("a", 1)
+		tx.Delete("b")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Remove("a")
+	for i := 0; i < 200; i++ {
+		m.Set(fmt.Sprint(i), i)
+	}
+	m.Reshard(64)
+	m.Reshard(1)
+}
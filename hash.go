@@ -0,0 +1,72 @@
+package cmap
+
+import (
+	"fmt"
+	"hash/maphash"
+	"reflect"
+	"unsafe"
+)
+
+// mix64 is the splitmix64 finalizer, used to spread integer keys over all bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// fold reduces a 64-bit hash to the 32 bits used for sharding.
+func fold(h uint64) uint32 {
+	return uint32(h ^ h>>32)
+}
+
+// comparableHash returns a seeded hash function for K.
+// Strings and integers take specialized fast paths, every other
+// comparable type is hashed with maphash.Comparable.
+func comparableHash[K comparable](seed maphash.Seed) func(K) uint32 {
+	var zero K
+	switch reflect.TypeFor[K]().Kind() {
+	case reflect.String:
+		return func(key K) uint32 {
+			return fold(maphash.String(seed, *(*string)(unsafe.Pointer(&key))))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		k := maphash.String(seed, "")
+		switch unsafe.Sizeof(zero) {
+		case 1:
+			return func(key K) uint32 {
+				return fold(mix64(uint64(*(*uint8)(unsafe.Pointer(&key))) ^ k))
+			}
+		case 2:
+			return func(key K) uint32 {
+				return fold(mix64(uint64(*(*uint16)(unsafe.Pointer(&key))) ^ k))
+			}
+		case 4:
+			return func(key K) uint32 {
+				return fold(mix64(uint64(*(*uint32)(unsafe.Pointer(&key))) ^ k))
+			}
+		case 8:
+			return func(key K) uint32 {
+				return fold(mix64(*(*uint64)(unsafe.Pointer(&key)) ^ k))
+			}
+		}
+	}
+	return func(key K) uint32 {
+		return fold(maphash.Comparable(seed, key))
+	}
+}
+
+// defaultSharding returns the built-in seeded hasher for K.
+func defaultSharding[K comparable, V any](seed maphash.Seed) ShardingFunc[K, V] {
+	return ShardingFunc[K, V](comparableHash[K](seed))
+}
+
+// stringerHash returns a seeded hash function for keys implementing fmt.Stringer.
+func stringerHash[K fmt.Stringer](seed maphash.Seed) func(K) uint32 {
+	return func(key K) uint32 {
+		return fold(maphash.String(seed, key.String()))
+	}
+}
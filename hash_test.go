@@ -0,0 +1,69 @@
+package cmap
+
+import (
+	"hash/maphash"
+	"strconv"
+	"testing"
+)
+
+type Integer8 int8
+
+// 测试内置哈希对相同的键得到相同的结果
+func TestComparableHashStable(t *testing.T) {
+	seed := maphash.MakeSeed()
+
+	str := comparableHash[string](seed)
+	if str("key") != str("k"+"ey") {
+		t.Error("相同的字符串应该得到相同的哈希")
+	}
+
+	i8 := comparableHash[Integer8](seed)
+	if i8(-1) != i8(Integer8(-1)) {
+		t.Error("相同的整数应该得到相同的哈希")
+	}
+
+	type pair struct {
+		a string
+		b int
+	}
+	p := comparableHash[pair](seed)
+	if p(pair{"a", 1}) != p(pair{"a", 1}) {
+		t.Error("相同的结构体应该得到相同的哈希")
+	}
+
+	ptr := comparableHash[*int](seed)
+	x := 1
+	if ptr(&x) != ptr(&x) {
+		t.Error("相同的指针应该得到相同的哈希")
+	}
+}
+
+// 测试不同的种子得到不同的哈希
+func TestComparableHashSeed(t *testing.T) {
+	a := comparableHash[string](maphash.MakeSeed())
+	b := comparableHash[string](maphash.MakeSeed())
+	same := 0
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if a(key) == b(key) {
+			same++
+		}
+	}
+	if same == 100 {
+		t.Error("不同的种子不应该得到完全相同的哈希")
+	}
+}
+
+// 测试整数键在分片间分布均匀
+func TestComparableHashDistribution(t *testing.T) {
+	h := comparableHash[uint64](maphash.MakeSeed())
+	var counts [32]int
+	for i := uint64(0); i < 32000; i++ {
+		counts[h(i<<8)&31]++
+	}
+	for i, c := range counts {
+		if c < 500 || c > 1500 {
+			t.Errorf("分片 %d 的键数量 %d 分布不均匀", i, c)
+		}
+	}
+}
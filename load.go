@@ -0,0 +1,150 @@
+package cmap
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// loadGroup tracks the GetOrLoad calls in flight, one per key.
+type loadGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+// loadCall is a loader running on behalf of one or more GetOrLoad callers.
+type loadCall[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+	// panicked is set if the loader panicked.
+	panicked *LoadPanic
+}
+
+// LoadPanic is the value GetOrLoad panics with when its loader panicked.
+// The loader runs on its own goroutine, so its panic is recovered there
+// and raised again in every caller waiting for it.
+type LoadPanic struct {
+	// Value is the value the loader panicked with.
+	Value any
+	// Stack is the stack of the loader's goroutine when it panicked.
+	Stack []byte
+}
+
+func (p *LoadPanic) Error() string {
+	return fmt.Sprintf("cmap: loader panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// Unwrap returns the value the loader panicked with, if it is an error.
+func (p *LoadPanic) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// GetOrLoad retrieves the element under key. If the key is missing, loader
+// is called to produce it, without holding any lock, and a successful
+// result is stored unless another value was stored meanwhile, in which
+// case that value is returned instead. Errors are returned but not stored.
+//
+// Concurrent calls for the same key share a single loader call. A caller
+// whose ctx is done stops waiting and returns ctx's error; the loader
+// keeps running for the other callers and its context, which carries the
+// values of the first caller's ctx, is only canceled once every caller
+// has stopped waiting. If loader panics, every caller still waiting
+// panics with a *LoadPanic.
+func (m ConcurrentMap[K, V]) GetOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, error)) (V, error) {
+	if v, ok := m.Get(key); ok {
+		return v, nil
+	}
+	c, v, ok := m.joinLoad(ctx, key, loader)
+	if ok {
+		return v, nil
+	}
+	select {
+	case <-c.done:
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.value, c.err
+	case <-ctx.Done():
+		m.leaveLoad(key, c)
+		var zero V
+		return zero, context.Cause(ctx)
+	}
+}
+
+// joinLoad returns the call loading key, starting one if there is none.
+// If the key was stored after GetOrLoad looked it up, it returns its value instead.
+func (m ConcurrentMap[K, V]) joinLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, error)) (c *loadCall[V], v V, ok bool) {
+	g := &m.state.loads
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if c = g.calls[key]; c != nil {
+		c.waiters++
+		return c, v, false
+	}
+	// a load finishing stores its value before leaving the group
+	if m.Has(key) {
+		if v, ok = m.Get(key); ok {
+			return nil, v, true
+		}
+	}
+	if g.calls == nil {
+		g.calls = make(map[K]*loadCall[V])
+	}
+	loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c = &loadCall[V]{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.calls[key] = c
+	go m.load(loadCtx, key, c, loader)
+	return c, v, false
+}
+
+// load runs loader for c and stores its result.
+func (m ConcurrentMap[K, V]) load(ctx context.Context, key K, c *loadCall[V], loader func(ctx context.Context) (V, error)) {
+	defer c.cancel()
+	c.value, c.panicked, c.err = m.run(ctx, key, loader)
+
+	g := &m.state.loads
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// run calls loader and stores its result, recovering a panic of loader.
+func (m ConcurrentMap[K, V]) run(ctx context.Context, key K, loader func(ctx context.Context) (V, error)) (v V, panicked *LoadPanic, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = &LoadPanic{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	v, err = loader(ctx)
+	if err == nil {
+		v, _ = m.ComputeIfAbsent(key, func() (V, bool) {
+			return v, true
+		})
+	}
+	return
+}
+
+// leaveLoad removes a caller that stopped waiting for c, canceling the
+// loader if it was the last one.
+func (m ConcurrentMap[K, V]) leaveLoad(key K, c *loadCall[V]) {
+	g := &m.state.loads
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c.waiters--
+	if c.waiters == 0 {
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		c.cancel()
+	}
+}
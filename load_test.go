@@ -0,0 +1,214 @@
+package cmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试并发加载同一个键时只调用一次 loader
+func TestGetOrLoadSingleFlight(t *testing.T) {
+	m := New[int]()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := m.GetOrLoad(context.Background(), "key", loader)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}()
+	}
+	// 等待所有调用者进入等待状态
+	for m.waiters("key") < len(results) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("loader 应只被调用1次，实际为 %d", calls.Load())
+	}
+	for _, v := range results {
+		if v != 42 {
+			t.Errorf("结果不正确: %d", v)
+		}
+	}
+	if v, ok := m.Get("key"); !ok || v != 42 {
+		t.Error("加载的值应该被保存")
+	}
+
+	// 已存在的键不再调用 loader
+	v, err := m.GetOrLoad(context.Background(), "key", func(ctx context.Context) (int, error) {
+		t.Error("键存在时不应调用 loader")
+		return 0, nil
+	})
+	if err != nil || v != 42 {
+		t.Errorf("GetOrLoad() = %d, %v", v, err)
+	}
+}
+
+// 测试加载错误不会被缓存
+func TestGetOrLoadError(t *testing.T) {
+	m := New[int]()
+	errLoad := errors.New("load failed")
+
+	if _, err := m.GetOrLoad(context.Background(), "key", func(ctx context.Context) (int, error) {
+		return 0, errLoad
+	}); !errors.Is(err, errLoad) {
+		t.Errorf("应返回 loader 的错误，实际为 %v", err)
+	}
+	if m.Has("key") {
+		t.Error("错误不应被缓存")
+	}
+
+	v, err := m.GetOrLoad(context.Background(), "key", func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	if err != nil || v != 1 {
+		t.Errorf("失败后应重新加载: %d, %v", v, err)
+	}
+}
+
+// 测试 loader 的 panic 在调用者中重新抛出，且不会被缓存
+func TestGetOrLoadPanic(t *testing.T) {
+	m := New[int]()
+	errBoom := errors.New("boom")
+
+	func() {
+		defer func() {
+			p, ok := recover().(*LoadPanic)
+			if !ok || p.Value != errBoom || !errors.Is(p, errBoom) || len(p.Stack) == 0 {
+				t.Errorf("应抛出 *LoadPanic，实际为 %v", p)
+			}
+		}()
+		m.GetOrLoad(context.Background(), "key", func(ctx context.Context) (int, error) {
+			panic(errBoom)
+		})
+	}()
+	if m.Has("key") || m.waiters("key") != 0 {
+		t.Error("panic 后不应留下值或加载记录")
+	}
+
+	v, err := m.GetOrLoad(context.Background(), "key", func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	if err != nil || v != 1 {
+		t.Errorf("panic 后应重新加载: %d, %v", v, err)
+	}
+}
+
+// 测试 loader 运行时不持有分片锁，且不会覆盖期间写入的值
+func TestGetOrLoadNoLock(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 1})
+	v, err := m.GetOrLoad(context.Background(), "key", func(ctx context.Context) (int, error) {
+		// 同一个分片的写入不会被阻塞
+		m.Set("other", 1)
+		m.Set("key", 2)
+		return 3, nil
+	})
+	if err != nil || v != 2 {
+		t.Errorf("应返回加载期间写入的值: %d, %v", v, err)
+	}
+	if v, _ := m.Get("key"); v != 2 {
+		t.Error("加载结果不应覆盖已有的值")
+	}
+}
+
+// 测试 context 取消
+func TestGetOrLoadCancel(t *testing.T) {
+	m := New[int]()
+	ctx, cancel := context.WithCancel(context.Background())
+	loaderDone := make(chan error, 1)
+	started := make(chan struct{})
+	loader := func(ctx context.Context) (int, error) {
+		close(started)
+		// 所有调用者放弃后 loader 的 context 会被取消
+		<-ctx.Done()
+		loaderDone <- ctx.Err()
+		return 0, ctx.Err()
+	}
+
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := m.GetOrLoad(ctx, "key", loader); !errors.Is(err, context.Canceled) {
+		t.Errorf("应返回 context.Canceled，实际为 %v", err)
+	}
+
+	select {
+	case err := <-loaderDone:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("loader 的 context 应被取消，实际为 %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("loader 的 context 没有被取消")
+	}
+	if m.Has("key") {
+		t.Error("取消的加载不应保存值")
+	}
+}
+
+// 测试部分调用者取消时 loader 继续为其他调用者运行
+func TestGetOrLoadCancelOneWaiter(t *testing.T) {
+	m := New[int]()
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 7, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	result := make(chan int)
+	go func() {
+		v, err := m.GetOrLoad(context.Background(), "key", loader)
+		if err != nil {
+			t.Error(err)
+		}
+		result <- v
+	}()
+	for m.waiters("key") < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.GetOrLoad(ctx, "key", loader); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("应返回 context.DeadlineExceeded，实际为 %v", err)
+	}
+
+	close(release)
+	if v := <-result; v != 7 {
+		t.Errorf("其他调用者应得到加载的值，实际为 %d", v)
+	}
+}
+
+// waiters 返回正在等待 key 加载的调用者数量
+func (m ConcurrentMap[K, V]) waiters(key K) int {
+	g := &m.state.loads
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if c := g.calls[key]; c != nil {
+		return c.waiters
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package cmap
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Loader produces the value of a key missing from a LoadingCache.
+type Loader[K comparable, V any] interface {
+	Load(ctx context.Context, key K) (V, error)
+}
+
+// LoaderFunc adapts a function to the Loader interface.
+type LoaderFunc[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// Load calls f(ctx, key).
+func (f LoaderFunc[K, V]) Load(ctx context.Context, key K) (V, error) {
+	return f(ctx, key)
+}
+
+// LoadingOptions configures a LoadingCache.
+type LoadingOptions struct {
+	// RefreshAfter, if positive, is the age after which an entry is
+	// reloaded in the background by the next Get, which still returns the
+	// current value. Zero means entries are never refreshed.
+	RefreshAfter time.Duration
+	// MaxConcurrentLoads, if positive, bounds the number of loads running
+	// at once, counting both the loads of missing keys and refreshes.
+	MaxConcurrentLoads int
+	// MaxEntries and Admission bound the cache like the Options of a map.
+	MaxEntries int
+	Admission  Admission
+	// Clock returns the current time used to age entries.
+	// Zero means time.Now; tests can inject a fake clock.
+	Clock func() time.Time
+}
+
+// loadedEntry is a value of a LoadingCache and the time it was loaded.
+type loadedEntry[V any] struct {
+	value  V
+	loaded time.Time
+	// refreshing is set while the entry is being reloaded.
+	refreshing atomic.Bool
+}
+
+// LoadingCache is a read-through cache: Get loads missing keys with its
+// Loader, sharing a single load between concurrent callers of the same
+// key, and reloads entries older than RefreshAfter in the background
+// while serving the value it has.
+type LoadingCache[K comparable, V any] struct {
+	m      ConcurrentMap[K, *loadedEntry[V]]
+	loader Loader[K, V]
+	opts   LoadingOptions
+	// sem holds a token for every load running, nil if loads are unbounded.
+	sem chan struct{}
+	// ctx is the parent of refreshes, canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewLoadingCache creates a cache loading missing keys with loader.
+func NewLoadingCache[K comparable, V any](loader Loader[K, V], opts LoadingOptions) *LoadingCache[K, V] {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	c := &LoadingCache[K, V]{
+		m: NewWithOptions(Options[K, *loadedEntry[V]]{
+			MaxEntries: opts.MaxEntries,
+			Admission:  opts.Admission,
+			Clock:      opts.Clock,
+		}),
+		loader: loader,
+		opts:   opts,
+	}
+	if opts.MaxConcurrentLoads > 0 {
+		c.sem = make(chan struct{}, opts.MaxConcurrentLoads)
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// Get returns the value of key, loading it if missing. Concurrent calls
+// for a missing key share one load, see ConcurrentMap.GetOrLoad; errors
+// are returned to every caller of that load but not cached.
+//
+// An entry older than RefreshAfter is returned as is, and reloaded in the
+// background unless a reload is already running. If the reload fails or
+// the loader panics, the entry is kept and the next Get tries again.
+func (c *LoadingCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	e, err := c.m.GetOrLoad(ctx, key, func(ctx context.Context) (*loadedEntry[V], error) {
+		return c.load(ctx, key)
+	})
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	c.maybeRefresh(key, e)
+	return e.value, nil
+}
+
+// GetIfPresent returns the value of key without loading or refreshing it.
+func (c *LoadingCache[K, V]) GetIfPresent(key K) (V, bool) {
+	if e, ok := c.m.Get(key); ok {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores value under key as if it had just been loaded.
+func (c *LoadingCache[K, V]) Set(key K, value V) {
+	c.m.Set(key, c.entry(value))
+}
+
+// Invalidate removes key, so the next Get loads it again.
+// A refresh of key running meanwhile does not store its result.
+func (c *LoadingCache[K, V]) Invalidate(key K) {
+	c.m.Remove(key)
+}
+
+// Count returns the number of cached entries.
+func (c *LoadingCache[K, V]) Count() int {
+	return c.m.Count()
+}
+
+// Stats returns the hit, miss and eviction counts of a cache bounded by
+// MaxEntries.
+func (c *LoadingCache[K, V]) Stats() CacheStats {
+	return c.m.Stats()
+}
+
+// Close cancels the refreshes running and waits for them to return.
+// Get keeps working but no longer refreshes entries.
+// Close may be called more than once.
+func (c *LoadingCache[K, V]) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	c.cancel()
+	c.wg.Wait()
+	c.m.Close()
+}
+
+func (c *LoadingCache[K, V]) entry(value V) *loadedEntry[V] {
+	return &loadedEntry[V]{value: value, loaded: c.opts.Clock()}
+}
+
+// load runs the loader for key once a load slot is free.
+func (c *LoadingCache[K, V]) load(ctx context.Context, key K) (*loadedEntry[V], error) {
+	if c.sem != nil {
+		select {
+		case c.sem <- struct{}{}:
+			defer func() { <-c.sem }()
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+	v, err := c.loader.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return c.entry(v), nil
+}
+
+// maybeRefresh reloads e in the background if it is due and no reload of
+// it is running.
+func (c *LoadingCache[K, V]) maybeRefresh(key K, e *loadedEntry[V]) {
+	if c.opts.RefreshAfter <= 0 || c.opts.Clock().Sub(e.loaded) < c.opts.RefreshAfter {
+		return
+	}
+	if !e.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fresh, err := c.refresh(key)
+		if err != nil {
+			e.refreshing.Store(false)
+			return
+		}
+		// store only over e, the key may have been set or removed meanwhile
+		c.m.Compute(key, func(cur *loadedEntry[V], exists bool) (*loadedEntry[V], ComputeOp) {
+			if cur == e {
+				return fresh, ComputeStore
+			}
+			return cur, ComputeKeep
+		})
+	}()
+}
+
+// refresh reloads key for maybeRefresh. No caller waits for a refresh to
+// raise a panic of the loader in, so it is returned as a *LoadPanic error
+// instead of crashing the program.
+func (c *LoadingCache[K, V]) refresh(key K) (fresh *loadedEntry[V], err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &LoadPanic{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return c.load(c.ctx, key)
+}
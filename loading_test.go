@@ -0,0 +1,227 @@
+package cmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 等待 cond 成立，超时则测试失败
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 测试读取缺失的键时调用 Loader，错误不会被缓存
+func TestLoadingCacheGet(t *testing.T) {
+	var calls atomic.Int32
+	fail := errors.New("fail")
+	c := NewLoadingCache(LoaderFunc[string, int](func(ctx context.Context, key string) (int, error) {
+		calls.Add(1)
+		if key == "bad" {
+			return 0, fail
+		}
+		return len(key), nil
+	}), LoadingOptions{})
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if v, err := c.Get(context.Background(), "abc"); err != nil || v != 3 {
+			t.Errorf("Get() = %d, %v", v, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Loader 应只调用一次，实际为 %d", calls.Load())
+	}
+
+	if _, err := c.Get(context.Background(), "bad"); !errors.Is(err, fail) {
+		t.Errorf("应返回加载错误，实际为 %v", err)
+	}
+	if _, ok := c.GetIfPresent("bad"); ok || c.Count() != 1 {
+		t.Error("加载失败的键不应被缓存")
+	}
+
+	c.Set("abc", 10)
+	if v, _ := c.Get(context.Background(), "abc"); v != 10 {
+		t.Errorf("Set 后 Get() = %d", v)
+	}
+	c.Invalidate("abc")
+	if v, _ := c.Get(context.Background(), "abc"); v != 3 || calls.Load() != 3 {
+		t.Errorf("Invalidate 后应重新加载，Get() = %d", v)
+	}
+}
+
+// 测试每次读取只计入一次命中或未命中
+func TestLoadingCacheStats(t *testing.T) {
+	c := NewLoadingCache(LoaderFunc[string, int](func(ctx context.Context, key string) (int, error) {
+		return len(key), nil
+	}), LoadingOptions{MaxEntries: 100})
+	defer c.Close()
+
+	c.Get(context.Background(), "key")
+	c.Get(context.Background(), "key")
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+// 测试过期的元素在后台刷新，刷新期间返回旧值
+func TestLoadingCacheRefresh(t *testing.T) {
+	clock := newFakeClock()
+	var version atomic.Int32
+	release := make(chan struct{}, 1)
+	c := NewLoadingCache(LoaderFunc[string, int32](func(ctx context.Context, key string) (int32, error) {
+		if version.Load() > 0 {
+			<-release
+		}
+		return version.Add(1), nil
+	}), LoadingOptions{RefreshAfter: time.Minute, Clock: clock.Now})
+	defer c.Close()
+
+	ctx := context.Background()
+	if v, _ := c.Get(ctx, "key"); v != 1 {
+		t.Fatalf("Get() = %d", v)
+	}
+	clock.Advance(30 * time.Second)
+	if v, _ := c.Get(ctx, "key"); v != 1 {
+		t.Errorf("未到刷新时间 Get() = %d", v)
+	}
+
+	// 刷新进行中时返回旧值，且不会重复刷新
+	clock.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		if v, _ := c.Get(ctx, "key"); v != 1 {
+			t.Errorf("刷新期间 Get() = %d", v)
+		}
+	}
+	release <- struct{}{}
+	eventually(t, func() bool {
+		v, _ := c.GetIfPresent("key")
+		return v == 2
+	})
+	if version.Load() != 2 {
+		t.Errorf("应只刷新一次，实际加载了 %d 次", version.Load())
+	}
+
+	// 刷新期间被删除的键不会被刷新的结果写回
+	clock.Advance(time.Hour)
+	c.Get(ctx, "key")
+	c.Invalidate("key")
+	release <- struct{}{}
+	eventually(t, func() bool { return version.Load() == 3 })
+	c.Close()
+	if _, ok := c.GetIfPresent("key"); ok {
+		t.Error("被删除的键不应被刷新写回")
+	}
+}
+
+// 测试刷新失败时保留旧值，下次读取时重试
+func TestLoadingCacheRefreshError(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	c := NewLoadingCache(LoaderFunc[string, int](func(ctx context.Context, key string) (int, error) {
+		if calls.Add(1) == 2 {
+			return 0, errors.New("fail")
+		}
+		return int(calls.Load()), nil
+	}), LoadingOptions{RefreshAfter: time.Minute, Clock: clock.Now})
+	defer c.Close()
+
+	ctx := context.Background()
+	c.Get(ctx, "key")
+	clock.Advance(time.Hour)
+	c.Get(ctx, "key")
+	eventually(t, func() bool { return calls.Load() == 2 })
+
+	// 失败的刷新结束后下一次读取会再次刷新
+	eventually(t, func() bool {
+		v, _ := c.Get(ctx, "key")
+		return v == 3
+	})
+}
+
+// 测试刷新时 Loader panic 不会导致程序崩溃，保留旧值并在下次读取时重试
+func TestLoadingCacheRefreshPanic(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	c := NewLoadingCache(LoaderFunc[string, int](func(ctx context.Context, key string) (int, error) {
+		if calls.Add(1) == 2 {
+			panic("boom")
+		}
+		return int(calls.Load()), nil
+	}), LoadingOptions{RefreshAfter: time.Minute, Clock: clock.Now, MaxConcurrentLoads: 1})
+	defer c.Close()
+
+	ctx := context.Background()
+	c.Get(ctx, "key")
+	clock.Advance(time.Hour)
+	if v, _ := c.Get(ctx, "key"); v != 1 {
+		t.Errorf("刷新期间 Get() = %d", v)
+	}
+	eventually(t, func() bool { return calls.Load() == 2 })
+
+	// panic 的刷新释放了加载槽位，下一次读取会再次刷新
+	eventually(t, func() bool {
+		v, _ := c.Get(ctx, "key")
+		return v == 3
+	})
+}
+
+// 测试并发加载的数量不超过上限
+func TestLoadingCacheMaxConcurrentLoads(t *testing.T) {
+	var running, peak atomic.Int32
+	c := NewLoadingCache(LoaderFunc[int, int](func(ctx context.Context, key int) (int, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return key, nil
+	}), LoadingOptions{MaxConcurrentLoads: 2})
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Get(context.Background(), i); err != nil || v != i {
+				t.Errorf("Get(%d) = %d, %v", i, v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > 2 {
+		t.Errorf("并发加载数为 %d，超过上限2", peak.Load())
+	}
+
+	// 等待加载槽位时调用者可以取消
+	block := make(chan struct{})
+	c = NewLoadingCache(LoaderFunc[int, int](func(ctx context.Context, key int) (int, error) {
+		<-block
+		return key, nil
+	}), LoadingOptions{MaxConcurrentLoads: 1})
+	defer close(block)
+	defer c.Close()
+	go c.Get(context.Background(), 1)
+	eventually(t, func() bool { return len(c.sem) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("应返回超时错误，实际为 %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CacheStats counts the lookups and evictions of a bounded map.
+type CacheStats struct {
+	// Hits is the number of Get calls that found their key.
+	Hits uint64
+	// Misses is the number of Get calls that did not.
+	Misses uint64
+	// Evictions is the number of entries removed to respect the bound.
+	Evictions uint64
+}
+
+// HitRatio returns the fraction of Get calls that found their key.
+func (s CacheStats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// cacheCounters are the atomic counters behind CacheStats.
+type cacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func (c *cacheCounters) stats() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+// add adds the counts of other to c.
+func (c *cacheCounters) add(other *cacheCounters) {
+	c.hits.Add(other.hits.Load())
+	c.misses.Add(other.misses.Load())
+	c.evictions.Add(other.evictions.Load())
+}
+
+// lruNode links a key into the recency list of its shard.
+type lruNode[K comparable] struct {
+	key        K
+	prev, next *lruNode[K]
+	// window tells whether the node is in the admission window.
+	window bool
+	cost   int64
+}
+
+// lru orders the keys of a shard from the most to the least recently used
+// and keeps the shard within its entry limit and cost budget.
+// Its methods lock mu, so a Get holding only the shard's read lock can
+// promote the key it found.
+//
+// With TinyLFU admission, new keys enter a separate window list, see
+// TinyLFU, and the sketch records every Get.
+type lru[K comparable] struct {
+	cacheCounters
+	mu     sync.Mutex
+	root   lruNode[K]
+	nodes  map[K]*lruNode[K]
+	limit  int
+	budget int64
+	// cost is the total cost of the keys, readable without locking.
+	cost atomic.Int64
+	// free is the last removed node, reused by the next insertion
+	// since a full shard removes a key for every key it adds.
+	free *lruNode[K]
+
+	win       lruNode[K]
+	winLen    int
+	winLimit  int
+	winCost   int64
+	winBudget int64
+	sketch    *frequencySketch
+	hash      func(K) uint32
+}
+
+// maxSketchKeys bounds the number of keys a shard's sketch is sized for
+// when the shard is only bounded by cost.
+const maxSketchKeys = 1 << 16
+
+// newLRU creates an lru for a shard holding up to limit keys costing up to budget.
+func newLRU[K comparable](limit int, budget int64) *lru[K] {
+	l := &lru[K]{limit: max(limit, 1), budget: max(budget, 1)}
+	l.reset()
+	return l
+}
+
+// newTinyLFU creates an lru admitting keys with W-TinyLFU.
+// hash must spread keys over all 32 bits.
+func newTinyLFU[K comparable](limit int, budget int64, hash func(K) uint32) *lru[K] {
+	l := newLRU[K](limit, budget)
+	l.winLimit = max(l.limit/100, 1)
+	l.winBudget = max(l.budget/100, 1)
+	l.sketch = newFrequencySketch(int(min(int64(l.limit), l.budget, maxSketchKeys)))
+	l.hash = hash
+	return l
+}
+
+// reset forgets every key. Frequencies are kept.
+func (l *lru[K]) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.root.prev, l.root.next = &l.root, &l.root
+	l.win.prev, l.win.next = &l.win, &l.win
+	l.winLen, l.winCost = 0, 0
+	l.cost.Store(0)
+	l.nodes = make(map[K]*lruNode[K])
+}
+
+// over reports whether the shard holds more keys or cost than allowed.
+func (l *lru[K]) over() bool {
+	return len(l.nodes) > l.limit || l.cost.Load() > l.budget
+}
+
+// record counts an access to key in the sketch, if any.
+func (l *lru[K]) record(key K) {
+	if l.sketch != nil {
+		l.sketch.increment(l.hash(key))
+	}
+}
+
+// miss records an access to a missing key.
+func (l *lru[K]) miss(key K) {
+	l.misses.Add(1)
+	if l.sketch != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.record(key)
+	}
+}
+
+// hit records an access to key and marks it as the most recently used.
+func (l *lru[K]) hit(key K) {
+	l.hits.Add(1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.record(key)
+	if n, ok := l.nodes[key]; ok {
+		l.promote(n)
+	}
+}
+
+// put records a write of key costing cost and marks it as the most
+// recently used, adding it if missing. Writes are not counted in the
+// sketch: a missing key is usually stored right after the Get that
+// missed it, which already counted the access.
+func (l *lru[K]) put(key K, cost int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, ok := l.nodes[key]
+	if !ok {
+		if n = l.free; n != nil {
+			l.free, n.key = nil, key
+		} else {
+			n = &lruNode[K]{key: key}
+		}
+		l.nodes[key] = n
+		n.window = l.sketch != nil
+		if n.window {
+			l.winLen++
+		}
+		n.prev, n.next = n, n
+	}
+	l.cost.Add(cost - n.cost)
+	if n.window {
+		l.winCost += cost - n.cost
+	}
+	n.cost = cost
+	l.promote(n)
+}
+
+// promote moves n to the front of its list.
+func (l *lru[K]) promote(n *lruNode[K]) {
+	unlink(n)
+	if n.window {
+		pushFront(&l.win, n)
+	} else {
+		pushFront(&l.root, n)
+	}
+}
+
+// remove forgets key.
+func (l *lru[K]) remove(key K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n, ok := l.nodes[key]; ok {
+		unlink(n)
+		l.cost.Add(-n.cost)
+		if n.window {
+			l.winLen--
+			l.winCost -= n.cost
+		}
+		delete(l.nodes, key)
+		*n = lruNode[K]{}
+		l.free = n
+	}
+}
+
+// victim returns the key to evict from a shard over its limit or budget.
+// With TinyLFU, the oldest keys of an oversized window move to the rest
+// of the shard, until one has to compete with its least recently used key.
+func (l *lru[K]) victim() (key K, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	mainLimit, mainBudget := l.limit-l.winLimit, l.budget-l.winBudget
+	for l.winLen > l.winLimit || l.winCost > l.winBudget {
+		candidate := l.win.prev
+		main := l.root.prev
+		roomy := len(l.nodes)-l.winLen < mainLimit &&
+			l.cost.Load()-l.winCost+candidate.cost <= mainBudget
+		if roomy || main == &l.root {
+			l.enterMain(candidate)
+			continue
+		}
+		if l.sketch.estimate(l.hash(candidate.key)) > l.sketch.estimate(l.hash(main.key)) {
+			l.enterMain(candidate)
+			return main.key, true
+		}
+		return candidate.key, true
+	}
+	if n := l.root.prev; n != &l.root {
+		return n.key, true
+	}
+	if n := l.win.prev; n != &l.win {
+		return n.key, true
+	}
+	return
+}
+
+// enterMain moves n from the window to the front of the main list.
+func (l *lru[K]) enterMain(n *lruNode[K]) {
+	unlink(n)
+	n.window = false
+	l.winLen--
+	l.winCost -= n.cost
+	pushFront(&l.root, n)
+}
+
+// ascend calls fn for every key and its cost, from the least to the most recently used.
+func (l *lru[K]) ascend(fn func(key K, cost int64)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, root := range []*lruNode[K]{&l.root, &l.win} {
+		for n := root.prev; n != root; n = n.prev {
+			fn(n.key, n.cost)
+		}
+	}
+}
+
+func unlink[K comparable](n *lruNode[K]) {
+	n.prev.next, n.next.prev = n.next, n.prev
+}
+
+func pushFront[K comparable](root, n *lruNode[K]) {
+	n.prev, n.next = root, root.next
+	n.prev.next, n.next.prev = n, n
+}
+
+// Cost returns the total cost of the entries of a map bounded by
+// Options.MaxEntries or Options.MaxCost. It is zero for an unbounded map.
+func (m ConcurrentMap[K, V]) Cost() int64 {
+	var cost int64
+	for _, shard := range m.settle().shards {
+		if shard.lru != nil {
+			cost += shard.lru.cost.Load()
+		}
+	}
+	return cost
+}
+
+// Stats returns the hit, miss and eviction counts of a map bounded by
+// Options.MaxEntries. They are zero for an unbounded map.
+func (m ConcurrentMap[K, V]) Stats() CacheStats {
+	var c cacheCounters
+	c.add(&m.state.retired)
+	for _, shard := range m.settle().shards {
+		if shard.lru != nil {
+			c.add(&shard.lru.cacheCounters)
+		}
+	}
+	return c.stats()
+}
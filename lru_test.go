@@ -0,0 +1,307 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试写满后淘汰最久未使用的元素
+func TestLRUEviction(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 1, MaxEntries: 3})
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+
+	// 读取 a 使其成为最近使用的元素
+	if _, ok := m.Get("a"); !ok {
+		t.Fatal("a 应该存在")
+	}
+	m.Set("d", 4)
+	if m.Has("b") {
+		t.Error("b 是最久未使用的元素，应该被淘汰")
+	}
+	if !m.Has("a") || !m.Has("c") || !m.Has("d") || m.Count() != 3 {
+		t.Error("其他元素应该保留")
+	}
+
+	// 覆盖已有的键不会淘汰元素，但会将其标记为最近使用
+	m.Set("c", 30)
+	m.Set("e", 5)
+	if m.Has("a") || !m.Has("c") {
+		t.Error("a 应该被淘汰，c 应该保留")
+	}
+
+	// Has 不影响使用顺序
+	m.Has("d")
+	m.Set("f", 6)
+	if m.Has("d") {
+		t.Error("Has 不应将元素标记为最近使用")
+	}
+
+	m.Get("missing")
+	stats := m.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 3 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	if stats.HitRatio() != 0.5 {
+		t.Errorf("HitRatio() = %v", stats.HitRatio())
+	}
+}
+
+// 测试上限平均分配到各个分片
+func TestLRUShardLimit(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 4, MaxEntries: 100})
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	for _, shard := range m.settle().shards {
+		if shard.Count() > 25 {
+			t.Errorf("每个分片最多25个元素，实际为 %d", shard.Count())
+		}
+	}
+	if stats := m.Stats(); int(stats.Evictions) != 1000-m.Count() {
+		t.Errorf("淘汰次数应为 %d，实际为 %d", 1000-m.Count(), stats.Evictions)
+	}
+
+	// 删除的元素不计入淘汰
+	m.Clear()
+	m.Set(1, 1)
+	m.Remove(1)
+	if m.Count() != 0 || m.Stats().Evictions != uint64(1000-100) {
+		t.Error("删除和清空不应计入淘汰次数")
+	}
+}
+
+// 测试各分片的上限之和恰好等于 MaxEntries，且每个分片至少能保存一个元素
+func TestLRUExactLimit(t *testing.T) {
+	for _, maxEntries := range []int{4, 10, 100} {
+		m := NewWithOptions(Options[int, int]{MaxEntries: maxEntries})
+		for i := 0; i < 10000; i++ {
+			m.Set(i, i)
+			if !m.Has(i) {
+				t.Fatalf("MaxEntries 为 %d 时刚写入的 %d 被淘汰", maxEntries, i)
+			}
+		}
+		total := 0
+		for _, shard := range m.settle().shards {
+			total += shard.lru.limit
+		}
+		if total != maxEntries || m.Count() > maxEntries {
+			t.Errorf("MaxEntries 为 %d 时上限之和为 %d，元素数量为 %d", maxEntries, total, m.Count())
+		}
+		if m.ShardCount() > maxEntries {
+			t.Errorf("MaxEntries 为 %d 时分片数为 %d", maxEntries, m.ShardCount())
+		}
+
+		// 重新分片同样不会超过 MaxEntries 个分片
+		m.Reshard(64)
+		if m.ShardCount() > maxEntries {
+			t.Errorf("MaxEntries 为 %d 时 Reshard(64) 后分片数为 %d", maxEntries, m.ShardCount())
+		}
+	}
+}
+
+// 测试扩容时保留使用顺序和统计数据
+func TestLRUReshard(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 1, MaxEntries: 8})
+	for i := 0; i < 8; i++ {
+		m.Set(i, i)
+	}
+	// 0 到 3 成为最近使用的元素
+	for i := 0; i < 4; i++ {
+		m.Get(i)
+	}
+	// 分片变小后每个分片仍然按使用顺序淘汰
+	m.state.maxEntries = 4
+	m.Reshard(2)
+	t1 := m.settle()
+	order := []int{4, 5, 6, 7, 0, 1, 2, 3} // 从最久未使用到最近使用
+	for i, shard := range t1.shards {
+		var keys []int
+		for _, k := range order {
+			if int(m.sharding(k)&t1.mask) == i {
+				keys = append(keys, k)
+			}
+		}
+		kept := keys[max(len(keys)-2, 0):]
+		if shard.Count() != len(kept) {
+			t.Errorf("分片 %d 应保留 %d 个元素，实际为 %d", i, len(kept), shard.Count())
+		}
+		for _, k := range kept {
+			if !m.Has(k) {
+				t.Errorf("最近使用的元素 %d 应该保留", k)
+			}
+		}
+	}
+	if stats := m.Stats(); stats.Hits != 4 || int(stats.Evictions) != 8-m.Count() {
+		t.Errorf("扩容后 Stats() = %+v", stats)
+	}
+}
+
+// 测试已过期的元素优先回收且不计入淘汰
+func TestLRUExpired(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[string, int]{ShardCount: 1, MaxEntries: 2, Clock: clock.Now})
+	defer m.Close()
+
+	m.SetWithTTL("a", 1, time.Second)
+	m.Set("b", 2)
+	clock.Advance(time.Minute)
+	m.Set("c", 3)
+	if !m.Has("b") || !m.Has("c") || m.Stats().Evictions != 0 {
+		t.Errorf("过期的元素不应计入淘汰: %+v", m.Stats())
+	}
+
+	// 过期的元素即使最近被使用过，也先于未过期的元素回收
+	m = NewWithOptions(Options[string, int]{ShardCount: 1, MaxEntries: 2, Clock: clock.Now})
+	defer m.Close()
+	var log evictLog
+	m.OnEvict(log.add)
+	m.SetWithTTL("a", 1, time.Second)
+	m.Set("b", 2)
+	m.Get("a")
+	clock.Advance(2 * time.Second)
+	m.Set("c", 3)
+	if got := log.take(); len(got) != 1 || got[0] != "a=1:expired" {
+		t.Errorf("通知为 %v，期望 [a=1:expired]", got)
+	}
+	if !m.Has("b") || !m.Has("c") || m.Count() != 2 || m.Stats().Evictions != 0 {
+		t.Errorf("未过期的元素应该保留: Count() = %d, %+v", m.Count(), m.Stats())
+	}
+}
+
+// 测试并发读写有上限的 map
+func TestLRUConcurrent(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 4, MaxEntries: 64})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa((g*31 + i) % 200)
+				if i%3 == 0 {
+					m.Set(key, i)
+				} else {
+					m.Get(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.Count() > 64 {
+		t.Errorf("元素数量不应超过上限，实际为 %d", m.Count())
+	}
+	for _, shard := range m.settle().shards {
+		if len(shard.lru.nodes) != len(shard.m) {
+			t.Error("使用记录应与元素一一对应")
+		}
+	}
+}
+
+// 测试按花费限制容量
+func TestCostBound(t *testing.T) {
+	m := NewWithOptions(Options[string, string]{
+		ShardCount: 1,
+		MaxCost:    100,
+		Cost:       func(v string) int64 { return int64(len(v)) },
+	})
+	value := func(n int) string {
+		return string(make([]byte, n))
+	}
+
+	m.Set("a", value(40))
+	m.Set("b", value(40))
+	if m.Cost() != 80 {
+		t.Errorf("Cost() 应为80，实际为 %d", m.Cost())
+	}
+	m.Get("a")
+	m.Set("c", value(30))
+	if m.Has("b") || !m.Has("a") || !m.Has("c") || m.Cost() != 70 {
+		t.Errorf("超过预算时应淘汰最久未使用的 b，Cost() = %d", m.Cost())
+	}
+
+	// 覆盖时更新花费
+	m.Set("c", value(10))
+	if m.Cost() != 50 {
+		t.Errorf("覆盖后 Cost() 应为50，实际为 %d", m.Cost())
+	}
+
+	// 显式指定花费
+	m.SetWithCost("d", "x", 50)
+	if m.Cost() != 100 || m.Count() != 3 {
+		t.Errorf("SetWithCost 后 Cost() = %d, Count() = %d", m.Cost(), m.Count())
+	}
+
+	// 花费超过预算的元素直接被淘汰，不影响其他元素
+	m.SetWithCost("huge", "x", 1000)
+	if m.Has("huge") || m.Count() != 3 || m.Cost() != 100 {
+		t.Error("花费超过预算的元素应被直接淘汰")
+	}
+
+	m.Remove("d")
+	if m.Cost() != 50 {
+		t.Errorf("删除后 Cost() 应为50，实际为 %d", m.Cost())
+	}
+	m.Clear()
+	if m.Cost() != 0 {
+		t.Errorf("清空后 Cost() 应为0，实际为 %d", m.Cost())
+	}
+	if stats := m.Stats(); stats.Evictions != 2 {
+		t.Errorf("淘汰次数应为2，实际为 %d", stats.Evictions)
+	}
+}
+
+// 测试各分片的预算之和恰好等于 MaxCost
+func TestCostExactBudget(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{MaxCost: 10})
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	var total int64
+	for _, shard := range m.settle().shards {
+		total += shard.lru.budget
+	}
+	if total != 10 || m.Cost() > 10 {
+		t.Errorf("预算之和为 %d，Cost() = %d", total, m.Cost())
+	}
+	if m.ShardCount() > 10 || m.Count() == 0 {
+		t.Errorf("分片数为 %d，元素数量为 %d", m.ShardCount(), m.Count())
+	}
+}
+
+// 测试同时限制元素数量和花费，以及扩容时保留花费
+func TestCostBoundReshard(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 1, MaxEntries: 10, MaxCost: 1000})
+	for i := 0; i < 20; i++ {
+		m.SetWithCost(i, i, 10)
+	}
+	if m.Count() != 10 || m.Cost() != 100 {
+		t.Errorf("Count() = %d, Cost() = %d", m.Count(), m.Cost())
+	}
+	for i := 0; i < 5; i++ {
+		m.SetWithCost(i, i, 200)
+	}
+	if m.Cost() > 1000 {
+		t.Errorf("总花费不应超过预算，实际为 %d", m.Cost())
+	}
+
+	before := m.Cost()
+	m.state.maxEntries, m.state.maxCost = 100, 100000
+	m.Reshard(4)
+	if m.Cost() != before {
+		t.Errorf("扩容后总花费应为 %d，实际为 %d", before, m.Cost())
+	}
+
+	// 缩小预算后扩容会淘汰元素
+	m.state.maxCost = 400
+	m.Reshard(8)
+	for _, shard := range m.settle().shards {
+		if c := shard.lru.cost.Load(); c > shard.lru.budget {
+			t.Errorf("分片花费 %d 超过预算 %d", c, shard.lru.budget)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cmap
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelRange calls fn for every key-value pair, processing up to workers
+// shards concurrently; workers <= 0 means GOMAXPROCS. As with IterCb, the
+// read lock of a shard is held while fn runs on its elements, so fn must be
+// safe to call from several goroutines at once.
+//
+// The first error returned by fn stops the iteration and is returned.
+// If ctx is done first, the iteration stops and the context's error is returned.
+func (m ConcurrentMap[K, V]) ParallelRange(ctx context.Context, workers int, fn func(key K, value V) error) error {
+	shards := m.settle().shards
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(shards))
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(shards) || ctx.Err() != nil {
+					return
+				}
+				if err := rangeShard(ctx, shards[i], fn); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// rangeShard calls fn for every element of shard until fn fails or ctx is done.
+func rangeShard[K comparable, V any](ctx context.Context, shard *SafeMap[K, V], fn func(K, V) error) (err error) {
+	done := ctx.Done()
+	shard.Range(func(k K, v V) bool {
+		select {
+		case <-done:
+			err = context.Cause(ctx)
+			return false
+		default:
+		}
+		err = fn(k, v)
+		return err == nil
+	})
+	return
+}
@@ -0,0 +1,87 @@
+package cmap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// 测试并行遍历所有元素
+func TestParallelRange(t *testing.T) {
+	m := NewComparable[int, int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+
+	var (
+		sum     atomic.Int64
+		counter atomic.Int64
+	)
+	err := m.ParallelRange(context.Background(), 4, func(key int, v int) error {
+		sum.Add(int64(v))
+		counter.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("不应该返回错误: %v", err)
+	}
+	if counter.Load() != 10000 || sum.Load() != 49995000 {
+		t.Errorf("遍历结果不正确: counter=%d sum=%d", counter.Load(), sum.Load())
+	}
+}
+
+// 测试回调返回错误时停止遍历并返回该错误
+func TestParallelRangeError(t *testing.T) {
+	m := NewComparable[int, int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+
+	errStop := errors.New("stop")
+	var counter atomic.Int64
+	err := m.ParallelRange(context.Background(), 0, func(key int, v int) error {
+		if counter.Add(1) == 100 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("应该返回回调的错误，实际为 %v", err)
+	}
+	if counter.Load() >= 10000 {
+		t.Error("出错后应停止遍历")
+	}
+}
+
+// 测试context取消时停止遍历
+func TestParallelRangeCancel(t *testing.T) {
+	m := NewComparable[int, int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var counter atomic.Int64
+	err := m.ParallelRange(ctx, 2, func(key int, v int) error {
+		if counter.Add(1) == 100 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("应该返回context.Canceled，实际为 %v", err)
+	}
+	if counter.Load() >= 10000 {
+		t.Error("取消后应停止遍历")
+	}
+
+	// 已取消的context不会调用回调
+	err = m.ParallelRange(ctx, 2, func(key int, v int) error {
+		t.Error("context已取消，不应调用回调")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("应该返回context.Canceled，实际为 %v", err)
+	}
+}
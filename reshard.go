@@ -0,0 +1,216 @@
+package cmap
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxShardCount bounds the automatic growth enabled by Options.GrowThreshold.
+const maxShardCount = 1 << 16
+
+// mapState is the part of a ConcurrentMap shared by all of its copies.
+type mapState[K comparable, V any] struct {
+	table    atomic.Pointer[shardTable[K, V]]
+	resizing sync.Mutex
+	growing  atomic.Bool
+	capacity int
+	growAt   int
+	loads    loadGroup[K, V]
+	clock    func() time.Time
+	janitor  janitor
+	// maxEntries bounds the number of entries, split evenly across shards.
+	maxEntries int
+	admission  Admission
+	// sketchHash hashes keys for the TinyLFU sketches. It has its own seed,
+	// since a custom sharding function may not spread keys over all bits.
+	sketchHash func(K) uint32
+	// maxCost bounds the total cost of the entries, split evenly across shards.
+	maxCost int64
+	costOf  func(V) int64
+	// retired holds the cache counters of the shards retired by Reshard.
+	retired   cacheCounters
+	listeners evictListeners[K, V]
+	// batches holds the *[]Tuple buffers MSet collects its pairs in.
+	batches sync.Pool
+	// scans numbers the scans started by Scan.
+	scans atomic.Uint32
+}
+
+// shardTable is one generation of shards.
+type shardTable[K comparable, V any] struct {
+	shards []*SafeMap[K, V]
+	mask   uint32
+	// old is the table whose entries are being migrated into this one.
+	// It is nil once every old shard has been migrated.
+	old atomic.Pointer[shardTable[K, V]]
+}
+
+// newTable creates a table of n empty shards.
+func (m ConcurrentMap[K, V]) newTable(n int) *shardTable[K, V] {
+	st := m.state
+	t := &shardTable[K, V]{
+		shards: make([]*SafeMap[K, V], n),
+		mask:   uint32(n - 1),
+	}
+	for i := range t.shards {
+		t.shards[i] = NewSafeWithCapacity[K, V](st.capacity)
+		t.shards[i].clock = st.clock
+		t.shards[i].listeners = &st.listeners
+		if st.maxEntries <= 0 && st.maxCost <= 0 {
+			continue
+		}
+		limit, budget := math.MaxInt, int64(math.MaxInt64)
+		if st.maxEntries > 0 {
+			limit = share(st.maxEntries, n, i)
+		}
+		if st.maxCost > 0 {
+			budget = share(st.maxCost, n, i)
+		}
+		switch st.admission {
+		case TinyLFU:
+			t.shards[i].lru = newTinyLFU(limit, budget, st.sketchHash)
+		default:
+			t.shards[i].lru = newLRU[K](limit, budget)
+		}
+		t.shards[i].costOf = st.costOf
+	}
+	return t
+}
+
+// share returns the part of total given to shard i of n. Every shard
+// gets total/n and the first total%n shards one more, so the parts add
+// up to total.
+func share[T int | int64](total T, n, i int) T {
+	part := total / T(n)
+	if T(i) < total%T(n) {
+		part++
+	}
+	return part
+}
+
+// fitShards lowers the shard count n, a power of two, until every shard of a
+// bounded map gets at least one entry of MaxEntries and one unit of MaxCost.
+func (st *mapState[K, V]) fitShards(n int) int {
+	bound := int64(math.MaxInt64)
+	if st.maxEntries > 0 {
+		bound = int64(st.maxEntries)
+	}
+	if st.maxCost > 0 {
+		bound = min(bound, st.maxCost)
+	}
+	for int64(n) > bound {
+		n >>= 1
+	}
+	return n
+}
+
+// locate returns the current table and the shard holding keys with hash h.
+// If a reshard is in progress, the old shard of h is migrated first, so the
+// returned shard is the only place the key can live.
+func (m ConcurrentMap[K, V]) locate(h uint32) (*shardTable[K, V], *SafeMap[K, V]) {
+	t := m.state.table.Load()
+	m.migrate(t, h)
+	return t, t.shards[h&t.mask]
+}
+
+// migrate moves the old shard of hash h into t, if t is being migrated into.
+func (m ConcurrentMap[K, V]) migrate(t *shardTable[K, V], h uint32) {
+	if old := t.old.Load(); old != nil {
+		if shard := old.shards[h&old.mask]; !shard.moved.Load() {
+			m.evacuate(t, shard)
+		}
+	}
+}
+
+// evacuate moves the entries of a shard of t's old table into t.
+// The old shard keeps its contents, frozen, for readers that still hold it;
+// writers see it is moved and retry against t.
+func (m ConcurrentMap[K, V]) evacuate(t *shardTable[K, V], old *SafeMap[K, V]) {
+	old.mux.Lock()
+	defer old.unlock()
+
+	if old.moved.Load() {
+		return
+	}
+	now := old.now()
+	move := func(k K, cost int64) {
+		if old.expired(k, now) {
+			old.record(k, old.m[k], EvictExpired)
+			return
+		}
+		// the new shard may evict for capacity, report it once old is unlocked
+		shard := t.shards[m.sharding(k)&t.mask]
+		shard.mux.Lock()
+		shard.put(k, old.m[k], cost, old.expiresAt(k))
+		old.pending = append(old.pending, shard.detach()...)
+		shard.mux.Unlock()
+	}
+	if old.lru != nil {
+		// keep the recency order, the new shards may be smaller
+		old.lru.ascend(move)
+		m.state.retired.add(&old.lru.cacheCounters)
+	} else {
+		for k := range old.m {
+			move(k, 1)
+		}
+	}
+	old.moved.Store(true)
+}
+
+// settle finishes the migration in progress, if any, and returns the current table.
+func (m ConcurrentMap[K, V]) settle() *shardTable[K, V] {
+	t := m.state.table.Load()
+	if old := t.old.Load(); old != nil {
+		for _, shard := range old.shards {
+			if !shard.moved.Load() {
+				m.evacuate(t, shard)
+			}
+		}
+		t.old.CompareAndSwap(old, nil)
+	}
+	return t
+}
+
+// Reshard changes the number of shards to n, rounded up to a power of two,
+// then lowered if needed so that every shard of a bounded map can hold an
+// entry, see Options.MaxEntries.
+// Entries are migrated one shard at a time while the map stays usable:
+// an operation on a key whose shard has not been migrated yet migrates
+// that shard first, and operations over the whole map finish the migration.
+// Reshard returns once every entry has been migrated.
+func (m ConcurrentMap[K, V]) Reshard(n int) {
+	m.state.resizing.Lock()
+	defer m.state.resizing.Unlock()
+
+	cur := m.settle()
+	n = m.state.fitShards(shardCount(n))
+	if n == len(cur.shards) {
+		return
+	}
+	next := m.newTable(n)
+	next.old.Store(cur)
+	m.state.table.Store(next)
+	m.settle()
+}
+
+// grow doubles the shard count of t in the background, unless the map has
+// already moved past t or another growth is running. It keeps doubling
+// while shards hold more than GrowThreshold keys on average.
+func (m ConcurrentMap[K, V]) grow(t *shardTable[K, V]) {
+	if !m.state.growing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer m.state.growing.Store(false)
+		for m.state.table.Load() == t && len(t.shards) < maxShardCount &&
+			m.state.fitShards(len(t.shards)*2) > len(t.shards) {
+			m.Reshard(len(t.shards) * 2)
+			t = m.state.table.Load()
+			if m.Count() <= m.state.growAt*len(t.shards) {
+				return
+			}
+		}
+	}()
+}
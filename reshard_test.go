@@ -0,0 +1,131 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试扩容和缩容后数据保持不变
+func TestReshard(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 4})
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	// map的副本共享同一组分片
+	copied := m
+
+	for _, n := range []int{64, 2, 100, 1} {
+		m.Reshard(n)
+		if copied.ShardCount() != shardCount(n) {
+			t.Errorf("Reshard(%d) 后分片数应为 %d，实际为 %d", n, shardCount(n), copied.ShardCount())
+		}
+		if copied.Count() != 1000 {
+			t.Errorf("Reshard(%d) 后应有1000个元素，实际为 %d", n, copied.Count())
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := copied.Get(strconv.Itoa(i)); !ok || v != i {
+				t.Fatalf("Reshard(%d) 后键 %d 的值不正确", n, i)
+			}
+		}
+	}
+}
+
+// 测试迁移过程中并发读写的正确性
+func TestReshardConcurrent(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 2})
+	for i := 0; i < 1000; i++ {
+		m.Set("static"+strconv.Itoa(i), i)
+	}
+
+	const (
+		writers    = 8
+		increments = 2000
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			key := "counter" + strconv.Itoa(w)
+			for i := 0; i < increments; i++ {
+				m.Upsert(key, func(v int, exist bool) int {
+					return v + 1
+				})
+				m.Set("last"+strconv.Itoa(w), i)
+				if _, ok := m.Get("static" + strconv.Itoa(i%1000)); !ok {
+					t.Errorf("迁移过程中丢失了键 static%d", i%1000)
+					return
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{8, 64, 4, 256, 16} {
+			m.Reshard(n)
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	for w := 0; w < writers; w++ {
+		if v, _ := m.Get("counter" + strconv.Itoa(w)); v != increments {
+			t.Errorf("计数器 %d 应为 %d，实际为 %d", w, increments, v)
+		}
+		if v, _ := m.Get("last" + strconv.Itoa(w)); v != increments-1 {
+			t.Errorf("键 last%d 应为 %d，实际为 %d", w, increments-1, v)
+		}
+	}
+	if m.Count() != 1000+2*writers {
+		t.Errorf("应有 %d 个元素，实际为 %d", 1000+2*writers, m.Count())
+	}
+}
+
+// 测试分片超过阈值时自动扩容
+func TestReshardGrowThreshold(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 2, GrowThreshold: 100})
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.ShardCount() < 64 {
+		if time.Now().After(deadline) {
+			t.Fatalf("分片数应自动增长，实际为 %d", m.ShardCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if m.Count() != 10000 {
+		t.Errorf("自动扩容后应有10000个元素，实际为 %d", m.Count())
+	}
+}
+
+// 测试写入已被迁移的分片时 panic，而不是丢失写入
+func TestReshardRetiredShard(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 4})
+	m.Set("k", 1)
+	shard := m.GetShard("k")
+	m.Reshard(8)
+
+	if v, ok := shard.Get("k"); !ok || v != 1 {
+		t.Error("迁移后的分片应保留原有数据供读取")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("写入已被迁移的分片应该 panic")
+		}
+		if v, ok := m.Get("k"); !ok || v != 1 {
+			t.Errorf("Get() = %d, %v", v, ok)
+		}
+		m.GetShard("k").Set("k", 2)
+		if v, _ := m.Get("k"); v != 2 {
+			t.Error("应该可以写入当前的分片")
+		}
+	}()
+	shard.Set("k", 3)
+}
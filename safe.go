@@ -2,19 +2,45 @@ package cmap
 
 import (
 	"encoding/json"
+	"iter"
 	"maps"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type SafeMap[K comparable, V any] struct {
 	m   map[K]V
 	mux sync.RWMutex
+	// moved 表示该分片已被 ConcurrentMap.Reshard 迁移，不再接受写入
+	moved atomic.Bool
+	// expires 记录设置了过期时间的键的过期时间（UnixNano），其中的键都在 m 中
+	expires map[K]*expiry[K]
+	// deadlines 按过期时间排列 expires 中的元素，用于快速找到已过期的键
+	deadlines expiryHeap[K]
+	// clock 返回当前时间，为 nil 时使用 time.Now
+	clock func() time.Time
+	// lru 不为 nil 时限制元素数量和总花费，超过上限后按淘汰策略删除元素
+	lru *lru[K]
+	// costOf 返回值的花费，为 nil 时每个元素的花费为 1
+	costOf func(V) int64
+	// listeners 为 nil 时不通知被移除的元素
+	listeners *evictListeners[K, V]
+	// pending 记录持有写锁期间被移除的元素，解锁后再通知
+	pending []evicted[K, V]
+	// scan 是 ConcurrentMap.Scan 建立的按扫描位置排序的键，扫描完该分片后释放
+	scan atomic.Pointer[scanIndex[K]]
 }
 
 // NewSafe 创建一个新的键和值类型为 K 和 V 的 SafeMap 类型指针
 func NewSafe[K comparable, V any]() *SafeMap[K, V] {
+	return NewSafeWithCapacity[K, V](0)
+}
+
+// NewSafeWithCapacity 创建一个预分配了 capacity 容量的 SafeMap
+func NewSafeWithCapacity[K comparable, V any](capacity int) *SafeMap[K, V] {
 	return &SafeMap[K, V]{
-		m:   make(map[K]V),
+		m:   make(map[K]V, capacity),
 		mux: sync.RWMutex{},
 	}
 }
@@ -27,9 +53,54 @@ func (s *SafeMap[K, V]) View(fn func(K, V)) {
 	defer s.mux.RUnlock()
 
 	// 遍历 SafeMap 中的键值对
-	for k, v := range s.m {
+	s.each(func(k K, v V) bool {
 		// 对每个键值对执行提供的函数
 		fn(k, v)
+		return true
+	})
+}
+
+// Range 对每个键值对调用 fn，直到 fn 返回 false 为止
+func (s *SafeMap[K, V]) Range(fn func(K, V) bool) {
+	// 读锁保护
+	s.mux.RLock()
+	// 最终解锁，确保即使发生错误，读锁也会被释放
+	defer s.mux.RUnlock()
+
+	s.each(fn)
+}
+
+// All 返回遍历所有键值对的迭代器，可用于 for range 循环
+// 遍历的是开始时的副本，循环体执行时不持有锁，可以修改 SafeMap
+func (s *SafeMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range s.Clone() {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// KeysSeq 返回遍历所有键的迭代器
+func (s *SafeMap[K, V]) KeysSeq() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range s.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// ValuesSeq 返回遍历所有值的迭代器
+func (s *SafeMap[K, V]) ValuesSeq() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range s.All() {
+			if !yield(v) {
+				return
+			}
+		}
 	}
 }
 
@@ -38,7 +109,7 @@ func (s *SafeMap[K, V]) Clone() map[K]V {
 	s.mux.RLock()
 	// 最终解锁，确保即使发生错误，读锁也会被释放
 	defer s.mux.RUnlock()
-	return maps.Clone(s.m)
+	return s.clone()
 }
 
 // Find 允许通过特定的键值集合来查找 SafeMap 中的值，并通过提供的函数进行处理
@@ -51,7 +122,7 @@ func (s *SafeMap[K, V]) Find(fn func(key K, value V, exist bool), keys ...K) {
 	// 遍历要查找的键的切片
 	for _, k := range keys {
 		// 尝试从 SafeMap 中获取键对应的值
-		v, ok := s.m[k]
+		v, ok := s.load(k)
 		// 对每个键值对执行提供的函数
 		fn(k, v, ok)
 	}
@@ -64,7 +135,7 @@ func (s *SafeMap[K, V]) Count() int {
 	defer s.mux.RUnlock()
 
 	// 返回map中的元素个数
-	return len(s.m)
+	return s.size()
 }
 
 // Get 方法用于获取键对应的值
@@ -75,7 +146,7 @@ func (s *SafeMap[K, V]) Get(key K) (V, bool) {
 	defer s.mux.RUnlock()
 
 	// 尝试获取键对应的值
-	v, ok := s.m[key]
+	v, ok := s.access(key)
 
 	// 返回值和bool类型的存在标志
 	return v, ok
@@ -89,7 +160,7 @@ func (s *SafeMap[K, V]) GetCb(key K, cb func(value V, exists bool)) {
 	defer s.mux.RUnlock()
 
 	// 尝试获取键对应的值
-	v, ok := s.m[key]
+	v, ok := s.access(key)
 
 	// 调用回调函数
 	cb(v, ok)
@@ -98,51 +169,499 @@ func (s *SafeMap[K, V]) GetCb(key K, cb func(value V, exists bool)) {
 // Set 方法用于设置键值对
 func (s *SafeMap[K, V]) Set(key K, value V) {
 	// 写锁保护，保证数据安全
-	s.mux.Lock()
+	s.lock()
 	// 使用 defer 确保即使发生错误，写锁也会被释放
-	defer s.mux.Unlock()
+	defer s.unlock()
 
 	// 设置键值对
-	s.m[key] = value
+	s.store(key, value)
+}
+
+// Swap 设置键值对并返回之前的值，loaded 表示键之前是否存在
+func (s *SafeMap[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	// 写锁保护
+	s.lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	previous, loaded = s.load(key)
+	s.store(key, value)
+	return
+}
+
+// LoadOrStore 键存在时返回已有的值，否则保存并返回 value
+// loaded 为 true 表示值是读取的，为 false 表示值是新保存的
+func (s *SafeMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	// 写锁保护
+	s.lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	if actual, loaded = s.load(key); loaded {
+		return
+	}
+	s.store(key, value)
+	return value, false
 }
 
 // Del 方法用于删除 SafeMap 中的指定键值对
 func (s *SafeMap[K, V]) Del(key K) {
 	// 写锁保护
-	s.mux.Lock()
+	s.lock()
 	// 使用 defer 确保即使发生错误，写锁也会被释放
-	defer s.mux.Unlock()
+	defer s.unlock()
 
 	// 删除键值对
-	delete(s.m, key)
+	s.delete(key)
 }
 
 // Update 允许通过特定的更新逻辑更新 SafeMap 中的值
+// fn 看到的 map 不包含已过期的键，fn 覆盖的键保留原来的过期时间
 func (s *SafeMap[K, V]) Update(fn func(map[K]V)) {
 	// 写锁保护
-	s.mux.Lock()
+	s.lock()
 	// 最终解锁，确保即使发生错误，写锁也会被释放
-	defer s.mux.Unlock()
+	defer s.unlock()
 
+	s.purge()
 	// 调用提供的更新函数，并获取返回值
 	fn(s.m)
+	s.resync()
+}
+
+// Clear 清空 SafeMap，用新的 map 替换内部 map 以释放旧 map 占用的内存
+func (s *SafeMap[K, V]) Clear() {
+	// 写锁保护
+	s.lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	s.purge()
+	s.recordAll(s.m)
+	s.m = make(map[K]V)
+	s.expires, s.deadlines = nil, nil
+	if s.lru != nil {
+		s.lru.reset()
+	}
+}
+
+// tryReset 用容量为 capacity 的新 map 替换内部 map 并返回旧的 map
+// 分片已被迁移时不做修改并返回 false
+func (s *SafeMap[K, V]) tryReset(capacity int) (map[K]V, bool) {
+	// 写锁保护
+	s.mux.Lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	if s.moved.Load() {
+		return nil, false
+	}
+	s.purge()
+	s.recordAll(s.m)
+	old := s.m
+	s.m = make(map[K]V, capacity)
+	s.expires, s.deadlines = nil, nil
+	if s.lru != nil {
+		s.lru.reset()
+	}
+	return old, true
+}
+
+// RemoveIf 在写锁保护下删除所有 pred 返回 true 的键值对，返回删除的数量
+func (s *SafeMap[K, V]) RemoveIf(pred func(K, V) bool) (removed int) {
+	// 写锁保护
+	s.lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	return s.removeIf(pred)
+}
+
+// Retain 只保留 pred 返回 true 的键值对，返回删除的数量
+func (s *SafeMap[K, V]) Retain(pred func(K, V) bool) int {
+	return s.RemoveIf(func(k K, v V) bool {
+		return !pred(k, v)
+	})
+}
+
+// view 在持有读锁时调用 fn
+func (s *SafeMap[K, V]) view(fn func(s *SafeMap[K, V])) {
+	// 读锁保护
+	s.mux.RLock()
+	// 最终解锁，确保即使发生错误，读锁也会被释放
+	defer s.mux.RUnlock()
+
+	fn(s)
+}
+
+// tryUpdate 在持有写锁时调用 fn，但分片已被迁移时不调用 fn 并返回 false
+func (s *SafeMap[K, V]) tryUpdate(fn func(s *SafeMap[K, V])) bool {
+	// 写锁保护
+	s.mux.Lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	if s.moved.Load() {
+		return false
+	}
+	fn(s)
+	return true
+}
+
+// reclaim 删除所有已过期的键值对，分片已被迁移时不做修改
+func (s *SafeMap[K, V]) reclaim() {
+	// 写锁保护
+	s.mux.Lock()
+	// 最终解锁，确保即使发生错误，写锁也会被释放
+	defer s.unlock()
+
+	if !s.moved.Load() {
+		s.purge()
+	}
+}
+
+// lock 获取写锁，分片已被 ConcurrentMap.Reshard 迁移时 panic
+// 迁移后的分片不再属于 map，写入它的数据会丢失
+func (s *SafeMap[K, V]) lock() {
+	s.mux.Lock()
+	if s.moved.Load() {
+		s.mux.Unlock()
+		panic("cmap: write to a shard retired by Reshard")
+	}
+}
+
+// unlock 释放写锁，然后在锁外通知持有写锁期间被移除的元素
+// 没有需要通知的元素时直接解锁，可以被内联
+func (s *SafeMap[K, V]) unlock() {
+	if s.pending == nil {
+		s.mux.Unlock()
+		return
+	}
+	s.unlockNotify()
+}
+
+// unlockNotify 释放写锁并通知被移除的元素
+func (s *SafeMap[K, V]) unlockNotify() {
+	pending := s.detach()
+	s.mux.Unlock()
+
+	s.listeners.notify(pending)
+}
+
+// 以下方法要求调用者已持有锁，读取时会跳过已过期的键
+
+// detach 取出等待通知的元素，同时持有多个锁时由调用者在全部解锁后通知
+func (s *SafeMap[K, V]) detach() []evicted[K, V] {
+	pending := s.pending
+	s.pending = nil
+	return pending
+}
+
+// record 记录被移除的元素，在解锁后通知
+func (s *SafeMap[K, V]) record(key K, value V, reason EvictReason) {
+	if s.listeners.active() {
+		s.pending = append(s.pending, evicted[K, V]{key, value, reason})
+	}
+}
+
+// recordAll 记录 m 中被显式删除的所有元素
+func (s *SafeMap[K, V]) recordAll(m map[K]V) {
+	if s.listeners.active() {
+		for k, v := range m {
+			s.record(k, v, EvictExplicit)
+		}
+	}
+}
+
+// removeIf 删除所有 pred 返回 true 的未过期的键值对，返回删除的数量
+func (s *SafeMap[K, V]) removeIf(pred func(K, V) bool) (removed int) {
+	s.purge()
+	for k, v := range s.m {
+		if pred(k, v) {
+			s.delete(k)
+			removed++
+		}
+	}
+	return
+}
+
+// now 返回当前时间（UnixNano）
+func (s *SafeMap[K, V]) now() int64 {
+	if s.clock != nil {
+		return s.clock().UnixNano()
+	}
+	return time.Now().UnixNano()
+}
+
+// deadline 返回 now 时刻之后的 ttl 对应的过期时间
+func (s *SafeMap[K, V]) deadline(ttl time.Duration) int64 {
+	return s.now() + int64(ttl)
+}
+
+// reason 返回移除 key 的原因，已过期的键总是 EvictExpired
+func (s *SafeMap[K, V]) reason(key K, reason EvictReason) EvictReason {
+	if len(s.expires) > 0 && s.expired(key, s.now()) {
+		return EvictExpired
+	}
+	return reason
+}
+
+// expired 判断 key 在 now 时刻是否已过期
+func (s *SafeMap[K, V]) expired(key K, now int64) bool {
+	e, ok := s.expires[key]
+	return ok && e.deadline <= now
+}
+
+// expiresAt 返回键的过期时间，没有设置过期时间时返回 0
+func (s *SafeMap[K, V]) expiresAt(key K) int64 {
+	if e, ok := s.expires[key]; ok {
+		return e.deadline
+	}
+	return 0
+}
+
+// load 获取键对应的值，已过期的键视为不存在
+func (s *SafeMap[K, V]) load(key K) (V, bool) {
+	v, ok := s.m[key]
+	if ok && len(s.expires) > 0 && s.expired(key, s.now()) {
+		var zero V
+		return zero, false
+	}
+	return v, ok
+}
+
+// access 与 load 相同，但会记录命中情况并把找到的键标记为最近使用
+func (s *SafeMap[K, V]) access(key K) (V, bool) {
+	v, ok := s.load(key)
+	if s.lru != nil {
+		if ok {
+			s.lru.hit(key)
+		} else {
+			s.lru.miss(key)
+		}
+	}
+	return v, ok
+}
+
+// store 设置键值对，并清除键的过期时间
+func (s *SafeMap[K, V]) store(key K, value V) {
+	s.put(key, value, s.weigh(value), 0)
+}
+
+// put 设置花费为 cost 的键值对，deadline 为 0 时表示永不过期
+// 超过上限时按淘汰策略删除元素，花费超过预算的元素会被直接淘汰
+func (s *SafeMap[K, V]) put(key K, value V, cost, deadline int64) {
+	if s.listeners.active() {
+		if old, ok := s.m[key]; ok {
+			s.record(key, old, s.reason(key, EvictReplaced))
+		}
+	}
+	s.m[key] = value
+	if deadline != 0 {
+		s.expire(key, deadline)
+	} else if len(s.expires) > 0 {
+		s.persist(key)
+	}
+	if s.lru == nil {
+		return
+	}
+	cost = max(cost, 0)
+	if cost > s.lru.budget {
+		s.lru.evictions.Add(1)
+		s.drop(key, EvictCapacity)
+		return
+	}
+	s.lru.put(key, cost)
+	s.evict()
+}
+
+// weigh 返回值的花费
+func (s *SafeMap[K, V]) weigh(value V) int64 {
+	if s.costOf != nil && s.lru != nil {
+		return s.costOf(value)
+	}
+	return 1
+}
+
+// delete 删除键值对，返回被删除的未过期的值
+func (s *SafeMap[K, V]) delete(key K) (V, bool) {
+	v, ok := s.load(key)
+	s.drop(key, EvictExplicit)
+	return v, ok
+}
+
+// drop 因为 reason 删除键值对及其过期时间和使用记录
+func (s *SafeMap[K, V]) drop(key K, reason EvictReason) {
+	if s.listeners.active() {
+		if v, ok := s.m[key]; ok {
+			s.record(key, v, s.reason(key, reason))
+		}
+	}
+	delete(s.m, key)
+	if len(s.expires) > 0 {
+		s.persist(key)
+	}
+	if s.lru != nil {
+		s.lru.remove(key)
+	}
+}
+
+// evict 在元素数量或总花费超过上限时先回收已过期的元素，仍然超过上限时再按淘汰策略删除元素
+// 已过期的元素不计入淘汰次数
+func (s *SafeMap[K, V]) evict() {
+	if s.lru.over() {
+		s.purge()
+	}
+	for s.lru.over() {
+		key, ok := s.lru.victim()
+		if !ok {
+			return
+		}
+		reason := s.reason(key, EvictCapacity)
+		if reason == EvictCapacity {
+			s.lru.evictions.Add(1)
+		}
+		s.drop(key, reason)
+	}
+}
+
+// resync 在直接修改 m 之后更新过期时间和使用记录
+func (s *SafeMap[K, V]) resync() {
+	for k := range s.expires {
+		if _, ok := s.m[k]; !ok {
+			s.persist(k)
+		}
+	}
+	if s.lru == nil {
+		return
+	}
+	var gone []K
+	s.lru.ascend(func(k K, _ int64) {
+		if _, ok := s.m[k]; !ok {
+			gone = append(gone, k)
+		}
+	})
+	for _, k := range gone {
+		s.lru.remove(k)
+	}
+	for k := range s.m {
+		if _, ok := s.lru.nodes[k]; !ok {
+			s.lru.put(k, s.weigh(s.m[k]))
+		}
+	}
+	s.evict()
+}
+
+// expire 设置已存在的键的过期时间
+func (s *SafeMap[K, V]) expire(key K, deadline int64) {
+	if e, ok := s.expires[key]; ok {
+		// 推迟的过期时间由 purge 调整位置，使覆盖写入不需要移动元素
+		e.deadline = deadline
+		if deadline < e.order {
+			e.order = deadline
+			s.deadlines.up(e.index)
+		}
+		return
+	}
+	if s.expires == nil {
+		s.expires = make(map[K]*expiry[K])
+	}
+	e := &expiry[K]{deadline: deadline, order: deadline, key: key}
+	s.expires[key] = e
+	s.deadlines.push(e)
+}
+
+// persist 清除键的过期时间，返回键是否设置过过期时间
+func (s *SafeMap[K, V]) persist(key K) bool {
+	e, ok := s.expires[key]
+	if ok {
+		delete(s.expires, key)
+		s.deadlines.remove(e.index)
+	}
+	return ok
+}
+
+// purge 删除所有已过期的键值对
+func (s *SafeMap[K, V]) purge() {
+	if len(s.expires) == 0 {
+		return
+	}
+	now := s.now()
+	for len(s.deadlines) > 0 && s.deadlines[0].order <= now {
+		if e := s.deadlines[0]; e.deadline > now {
+			e.order = e.deadline
+			s.deadlines.down(0)
+		} else {
+			s.drop(e.key, EvictExpired)
+		}
+	}
+}
+
+// size 返回未过期的元素数量，只需访问已过期的键
+func (s *SafeMap[K, V]) size() int {
+	n := len(s.m)
+	if len(s.expires) > 0 {
+		s.deadlines.expired(s.now(), func(*expiry[K]) {
+			n--
+		})
+	}
+	return n
+}
+
+// each 对每个未过期的键值对调用 fn，直到 fn 返回 false 为止
+func (s *SafeMap[K, V]) each(fn func(K, V) bool) {
+	if len(s.expires) == 0 {
+		for k, v := range s.m {
+			if !fn(k, v) {
+				return
+			}
+		}
+		return
+	}
+	now := s.now()
+	for k, v := range s.m {
+		if s.expired(k, now) {
+			continue
+		}
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// clone 复制所有未过期的键值对
+func (s *SafeMap[K, V]) clone() map[K]V {
+	c := maps.Clone(s.m)
+	if len(s.expires) > 0 {
+		s.deadlines.expired(s.now(), func(e *expiry[K]) {
+			delete(c, e.key)
+		})
+	}
+	return c
 }
 
 func (s *SafeMap[K, V]) MarshalJSON() ([]byte, error) {
 	// 写锁保护
 	s.mux.Lock()
 	// 最终解锁，确保即使发生错误，写锁也会被释放
-	defer s.mux.Unlock()
+	defer s.unlock()
 
-	return json.Marshal(s.m)
+	if len(s.expires) == 0 {
+		return json.Marshal(s.m)
+	}
+	return json.Marshal(s.clone())
 }
 
 // Reverse process of Marshal.
 func (s *SafeMap[K, V]) UnmarshalJSON(b []byte) (err error) {
 	// 写锁保护
-	s.mux.Lock()
+	s.lock()
 	// 最终解锁，确保即使发生错误，写锁也会被释放
-	defer s.mux.Unlock()
+	defer s.unlock()
 
-	return json.Unmarshal(b, &s.m)
+	if err = json.Unmarshal(b, &s.m); err != nil {
+		return err
+	}
+	s.resync()
+	return nil
 }
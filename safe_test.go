@@ -2,8 +2,10 @@ package cmap
 
 import (
 	"encoding/json"
+	"math"
 	"slices"
 	"testing"
+	"time"
 )
 
 func TestSafeMap_View(t *testing.T) {
@@ -212,3 +214,175 @@ func TestSafeMap_FindComprehensive(t *testing.T) {
 		t.Error("key3应标记为未找到")
 	}
 }
+
+// 测试 SafeMap 的迭代器
+func TestSafeMap_All(t *testing.T) {
+	sm := NewSafe[string, int]()
+	sm.Set("key1", 1)
+	sm.Set("key2", 2)
+	sm.Set("key3", 3)
+
+	sum := 0
+	for k, v := range sm.All() {
+		// 循环体中可以修改 SafeMap
+		sm.Del(k)
+		sum += v
+	}
+	if sum != 6 || sm.Count() != 0 {
+		t.Errorf("遍历结果不正确: sum=%d count=%d", sum, sm.Count())
+	}
+
+	sm.Set("key1", 1)
+	sm.Set("key2", 2)
+	keys := slices.Collect(sm.KeysSeq())
+	if len(keys) != 2 || !slices.Contains(keys, "key1") || !slices.Contains(keys, "key2") {
+		t.Errorf("键不正确: %v", keys)
+	}
+
+	counter := 0
+	for range sm.ValuesSeq() {
+		counter++
+		break
+	}
+	if counter != 1 {
+		t.Error("break应该停止遍历")
+	}
+}
+
+// 测试 SafeMap 可提前终止的遍历
+func TestSafeMap_Range(t *testing.T) {
+	sm := NewSafe[string, int]()
+	for i := 0; i < 10; i++ {
+		sm.Set(string(rune('a'+i)), i)
+	}
+
+	counter := 0
+	sm.Range(func(k string, v int) bool {
+		counter++
+		return true
+	})
+	if counter != 10 {
+		t.Errorf("应该遍历10个元素，实际为 %d", counter)
+	}
+
+	counter = 0
+	sm.Range(func(k string, v int) bool {
+		counter++
+		return false
+	})
+	if counter != 1 {
+		t.Errorf("回调返回false后应停止遍历，实际遍历了 %d 个", counter)
+	}
+}
+
+// 测试清空 SafeMap
+func TestSafeMap_Clear(t *testing.T) {
+	sm := NewSafe[string, int]()
+	sm.Set("key1", 1)
+	sm.Set("key2", 2)
+
+	sm.Clear()
+	if sm.Count() != 0 {
+		t.Error("清空后 SafeMap 应为空")
+	}
+
+	sm.Set("key3", 3)
+	if v, ok := sm.Get("key3"); !ok || v != 3 {
+		t.Error("清空后应该可以继续写入")
+	}
+}
+
+// 测试 SafeMap 按条件批量删除
+func TestSafeMap_RemoveIf(t *testing.T) {
+	sm := NewSafe[string, int]()
+	for i := 0; i < 10; i++ {
+		sm.Set(string(rune('a'+i)), i)
+	}
+
+	if removed := sm.RemoveIf(func(k string, v int) bool { return v >= 5 }); removed != 5 {
+		t.Errorf("应删除5个元素，实际为 %d", removed)
+	}
+	if removed := sm.Retain(func(k string, v int) bool { return v == 0 }); removed != 4 {
+		t.Errorf("应删除4个元素，实际为 %d", removed)
+	}
+	if v, ok := sm.Get("a"); !ok || v != 0 || sm.Count() != 1 {
+		t.Error("应只剩下键a")
+	}
+}
+
+// 测试 SafeMap 的 Swap 和 LoadOrStore
+func TestSafeMap_SwapLoadOrStore(t *testing.T) {
+	sm := NewSafe[string, int]()
+
+	if prev, loaded := sm.Swap("key", 1); loaded || prev != 0 {
+		t.Errorf("Swap() = %d, %v", prev, loaded)
+	}
+	if prev, loaded := sm.Swap("key", 2); !loaded || prev != 1 {
+		t.Errorf("Swap() = %d, %v", prev, loaded)
+	}
+
+	if actual, loaded := sm.LoadOrStore("key", 3); !loaded || actual != 2 {
+		t.Errorf("LoadOrStore() = %d, %v", actual, loaded)
+	}
+	if actual, loaded := sm.LoadOrStore("other", 4); loaded || actual != 4 {
+		t.Errorf("LoadOrStore() = %d, %v", actual, loaded)
+	}
+	if v, ok := sm.Get("other"); !ok || v != 4 {
+		t.Error("LoadOrStore 应该保存新值")
+	}
+}
+
+// 测试 SafeMap 的读取和遍历跳过过期的键
+func TestSafeMap_Expired(t *testing.T) {
+	clock := newFakeClock()
+	sm := NewSafe[string, int]()
+	sm.clock = clock.Now
+	sm.Set("key1", 1)
+	sm.put("key2", 2, 1, clock.Now().Add(time.Second).UnixNano())
+
+	clock.Advance(time.Minute)
+	if _, ok := sm.Get("key2"); ok || sm.Count() != 1 || len(sm.Clone()) != 1 {
+		t.Error("过期的键不应可见")
+	}
+	data, _ := sm.MarshalJSON()
+	if string(data) != `{"key1":1}` {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+
+	// Update 回调看不到过期的键
+	sm.Update(func(m map[string]int) {
+		if _, ok := m["key2"]; ok {
+			t.Error("Update 不应看到过期的键")
+		}
+		delete(m, "key1")
+	})
+	if len(sm.m) != 0 || len(sm.expires) != 0 {
+		t.Error("Update 后应清理过期的键")
+	}
+}
+
+// 测试有上限的 SafeMap 在直接修改 map 后仍然遵守上限
+func TestSafeMap_Bounded(t *testing.T) {
+	sm := NewSafe[string, int]()
+	sm.lru = newLRU[string](2, math.MaxInt64)
+	sm.Set("key1", 1)
+	sm.Set("key2", 2)
+	sm.Get("key1")
+
+	sm.Update(func(m map[string]int) {
+		m["key3"] = 3
+	})
+	if sm.Count() != 2 || len(sm.lru.nodes) != 2 {
+		t.Errorf("Update 后元素数量应为2，实际为 %d", sm.Count())
+	}
+	if _, ok := sm.Get("key1"); !ok {
+		t.Error("最近使用的 key1 应该保留")
+	}
+
+	if err := sm.UnmarshalJSON([]byte(`{"key4":4,"key5":5}`)); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Count() != 2 || len(sm.lru.nodes) != 2 {
+		t.Errorf("UnmarshalJSON 后元素数量应为2，实际为 %d", sm.Count())
+	}
+}
@@ -0,0 +1,113 @@
+package cmap
+
+import (
+	"cmp"
+	"math/bits"
+	"slices"
+)
+
+// defaultScanCount is the batch size used when Scan is called with count <= 0.
+const defaultScanCount = 10
+
+// scanEnd is one past the last position of the scan order.
+const scanEnd = 1 << 32
+
+// Scan returns a batch of about count entries starting at cursor, and the
+// cursor to pass to the next call. Start with cursor 0; a returned cursor of
+// 0 means the scan is complete.
+//
+// Entries are visited in the bit-reversed order of their shard hash, which
+// keeps every shard a contiguous range of cursors whatever the shard count.
+// So, like Redis SCAN, an entry present for the whole scan is returned at
+// least once even if the map is modified or resharded between calls, while
+// entries added or removed meanwhile may or may not be returned. Entries
+// with colliding hashes are always returned together, so a batch can exceed
+// count.
+//
+// The first call of a scan to visit a shard sorts the keys of the shard by
+// position, holding its read lock; the following calls only visit the
+// entries they return. So a full scan costs about one sort of every shard,
+// and the index of a shard is released once the scan is past it.
+func (m ConcurrentMap[K, V]) Scan(cursor uint64, count int) (next uint64, batch []Tuple[K, V]) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	// the cursor holds the number of the scan above the position
+	scan, pos := uint32(cursor>>32), cursor&(scanEnd-1)
+	for scan == 0 {
+		scan = m.state.scans.Add(1)
+	}
+	for pos < scanEnd && len(batch) < count {
+		t := m.settle()
+		shift := 32 - bits.TrailingZeros(uint(len(t.shards)))
+		shard := t.shards[bits.Reverse32(uint32(pos))&t.mask]
+		end := (pos>>shift + 1) << shift
+		batch, pos = m.scanShard(shard, scan, pos, end, count-len(batch), batch)
+	}
+	if pos >= scanEnd {
+		return 0, batch
+	}
+	return uint64(scan)<<32 | pos, batch
+}
+
+// scanShard appends to batch the entries of shard positioned in [pos, end),
+// stopping after the first need positions, and returns where to continue.
+func (m ConcurrentMap[K, V]) scanShard(shard *SafeMap[K, V], scan uint32, pos, end uint64, need int, batch []Tuple[K, V]) ([]Tuple[K, V], uint64) {
+	shard.mux.RLock()
+	defer shard.mux.RUnlock()
+
+	// An index built before the scan started may miss keys present for the
+	// whole scan, the index of a later scan holds all of them.
+	index := shard.scan.Load()
+	if index == nil || int32(index.scan-scan) < 0 {
+		index = m.indexShard(shard, scan)
+		shard.scan.Store(index)
+	}
+	now := shard.now()
+	keys := index.keys
+	i, _ := slices.BinarySearchFunc(keys, pos, func(k scanKey[K], pos uint64) int {
+		return cmp.Compare(uint64(k.pos), pos)
+	})
+	var last uint32
+	for ; i < len(keys) && uint64(keys[i].pos) < end; i++ {
+		k := keys[i]
+		if need <= 0 && k.pos != last {
+			return batch, uint64(k.pos)
+		}
+		// keys removed since the index was built are skipped
+		v, ok := shard.m[k.key]
+		if !ok || shard.expired(k.key, now) {
+			continue
+		}
+		batch = append(batch, Tuple[K, V]{k.key, v})
+		need--
+		last = k.pos
+	}
+	shard.scan.CompareAndSwap(index, nil)
+	return batch, end
+}
+
+// indexShard sorts the keys of shard by scan position for the given scan.
+func (m ConcurrentMap[K, V]) indexShard(shard *SafeMap[K, V], scan uint32) *scanIndex[K] {
+	keys := make([]scanKey[K], 0, len(shard.m))
+	for k := range shard.m {
+		keys = append(keys, scanKey[K]{bits.Reverse32(m.sharding(k)), k})
+	}
+	slices.SortFunc(keys, func(a, b scanKey[K]) int {
+		return cmp.Compare(a.pos, b.pos)
+	})
+	return &scanIndex[K]{scan: scan, keys: keys}
+}
+
+// scanIndex is the keys of a shard sorted by scan position.
+type scanIndex[K comparable] struct {
+	// scan is the number of the scan that built the index.
+	scan uint32
+	keys []scanKey[K]
+}
+
+// scanKey is a key and its scan position.
+type scanKey[K comparable] struct {
+	pos uint32
+	key K
+}
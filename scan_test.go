@@ -0,0 +1,142 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+// 测试完整扫描返回所有元素
+func TestScan(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	calls := 0
+	for {
+		next, batch := m.Scan(cursor, 50)
+		calls++
+		for _, item := range batch {
+			seen[item.Key]++
+		}
+		if next == 0 {
+			break
+		}
+		if len(batch) < 50 {
+			t.Errorf("未结束的扫描批次应至少包含50个元素，实际为 %d", len(batch))
+		}
+		cursor = next
+	}
+
+	if len(seen) != 1000 {
+		t.Errorf("应该扫描到1000个元素，实际为 %d", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("键 %s 被返回了 %d 次", k, n)
+		}
+	}
+	if calls < 20 {
+		t.Errorf("扫描应分批进行，实际只调用了 %d 次", calls)
+	}
+}
+
+// 测试空map的扫描
+func TestScanEmpty(t *testing.T) {
+	m := New[int]()
+	next, batch := m.Scan(0, 10)
+	if next != 0 || len(batch) != 0 {
+		t.Errorf("空map的扫描应立即结束: next=%d len=%d", next, len(batch))
+	}
+}
+
+// 测试哈希碰撞的元素在同一批次返回
+func TestScanCollisions(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{
+		Sharding: func(key string) uint32 {
+			return uint32(len(key))
+		},
+	})
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	// 一位数和两位数的键各自碰撞
+	next, batch := m.Scan(0, 1)
+	if len(batch) != 10 && len(batch) != 90 {
+		t.Errorf("碰撞的元素应一起返回，实际返回 %d 个", len(batch))
+	}
+	_, rest := m.Scan(next, 1000)
+	if len(batch)+len(rest) != 100 {
+		t.Errorf("应扫描到100个元素，实际为 %d", len(batch)+len(rest))
+	}
+}
+
+// 测试扫描期间修改和重新分片时，始终存在的元素至少返回一次
+func TestScanWhileResharding(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 4})
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+
+	seen := make(map[int]bool)
+	shards := []int{64, 2, 16, 1, 128}
+	var cursor uint64
+	for step := 0; ; step++ {
+		next, batch := m.Scan(cursor, 30)
+		for _, item := range batch {
+			seen[item.Key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+
+		// 在两次调用之间修改map
+		m.Reshard(shards[step%len(shards)])
+		m.Set(1000+step, step)
+		if step > 0 {
+			m.Remove(1000 + step - 1)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !seen[i] {
+			t.Errorf("键 %d 没有被扫描到", i)
+		}
+	}
+}
+
+// 测试新的扫描不会沿用之前的扫描建立的索引，扫描完分片后释放索引
+func TestScanIndex(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 1})
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	// 第一次扫描只走了一部分，之后写入的元素不在它的索引中
+	m.Scan(0, 10)
+	for i := 100; i < 200; i++ {
+		m.Set(i, i)
+	}
+
+	seen := make(map[int]bool)
+	var cursor uint64
+	for {
+		next, batch := m.Scan(cursor, 10)
+		for _, item := range batch {
+			seen[item.Key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 200 {
+		t.Errorf("应该扫描到200个元素，实际为 %d", len(seen))
+	}
+	if m.settle().shards[0].scan.Load() != nil {
+		t.Error("扫描完分片后应释放索引")
+	}
+}
@@ -0,0 +1,48 @@
+package cmap
+
+import "math/bits"
+
+// defaultSkewFactor is used when Options.OnSkew is set without a SkewFactor.
+const defaultSkewFactor = 8
+
+// minSkewShardSize keeps the detector quiet while shards are still small.
+const minSkewShardSize = 64
+
+// SkewFunc is called when a shard holds a disproportionate share of keys.
+// shard is the index of the shard, size its number of keys and mean the
+// average number of keys per shard at the time of the check.
+type SkewFunc func(shard, size int, mean float64)
+
+// skewDetector watches shard sizes for signs of hash flooding.
+// Checks only run when a shard grows past a power of two, so the
+// cost of summing all shards is amortized over many inserts.
+type skewDetector struct {
+	factor float64
+	report SkewFunc
+}
+
+func newSkewDetector(factor float64, report SkewFunc) *skewDetector {
+	if report == nil {
+		return nil
+	}
+	if factor <= 0 {
+		factor = defaultSkewFactor
+	}
+	return &skewDetector{factor: factor, report: report}
+}
+
+// due reports whether a shard that grew from before to after keys should be checked.
+// A batch write may grow a shard by many keys at once, so the shard is
+// checked whenever it reaches or jumps over a power of two.
+func (d *skewDetector) due(before, after int) bool {
+	return after >= minSkewShardSize && bits.Len(uint(before)) < bits.Len(uint(after))
+}
+
+// check compares size against the mean shard size and reports the shard if it is skewed.
+func (d *skewDetector) check(shard, size int, counts func() (total, shards int)) {
+	total, shards := counts()
+	mean := float64(total) / float64(shards)
+	if float64(size) > d.factor*mean {
+		d.report(shard, size, mean)
+	}
+}
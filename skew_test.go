@@ -0,0 +1,92 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+// 测试所有键落入同一分片时触发倾斜回调
+func TestSkewDetector(t *testing.T) {
+	var reports []int
+	m := NewWithOptions(Options[string, int]{
+		Sharding: func(key string) uint32 {
+			return 0
+		},
+		OnSkew: func(shard, size int, mean float64) {
+			if shard != 0 {
+				t.Errorf("倾斜的分片应该是0，实际为 %d", shard)
+			}
+			if float64(size) <= defaultSkewFactor*mean {
+				t.Errorf("分片大小 %d 相对均值 %f 不应报告倾斜", size, mean)
+			}
+			reports = append(reports, size)
+		},
+	})
+
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	// 分片大小达到64、128、256、512时各检查一次
+	if len(reports) != 4 {
+		t.Errorf("应该报告4次倾斜，实际为 %v", reports)
+	}
+}
+
+// 测试批量写入跨过2的幂时同样检查倾斜
+func TestSkewDetectorBatch(t *testing.T) {
+	reports := 0
+	m := NewWithOptions(Options[string, int]{
+		Sharding: func(key string) uint32 {
+			return 0
+		},
+		OnSkew: func(shard, size int, mean float64) {
+			reports++
+		},
+	})
+
+	data := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		data[strconv.Itoa(i)] = i
+	}
+	m.MSet(data)
+	if reports != 1 {
+		t.Errorf("批量写入应该报告1次倾斜，实际为 %d", reports)
+	}
+}
+
+// 测试均匀分布的键不会触发倾斜回调
+func TestSkewDetectorBalanced(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{
+		OnSkew: func(shard, size int, mean float64) {
+			t.Errorf("均匀分布时不应报告倾斜: shard=%d size=%d mean=%f", shard, size, mean)
+		},
+	})
+
+	for i := 0; i < 10000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+}
+
+// 测试针对fnv32构造的碰撞键在默认哈希下依然分布均匀
+func TestSeededHashResistsCraftedKeys(t *testing.T) {
+	// 构造在fnv32下全部落入同一分片的键
+	var keys []string
+	for i := 0; len(keys) < 1000; i++ {
+		key := strconv.Itoa(i)
+		if fnv32(key)&31 == 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	m := New[int]()
+	for _, key := range keys {
+		m.Set(key, 0)
+	}
+
+	for i, shard := range m.settle().shards {
+		if shard.Count() > len(keys)/4 {
+			t.Errorf("分片 %d 包含 %d 个键，分布不均匀", i, shard.Count())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cmap
+
+import (
+	"encoding/json"
+	"iter"
+)
+
+// Snapshot is an immutable, point-in-time copy of a ConcurrentMap.
+// It needs no locking and can be shared between goroutines.
+type Snapshot[K comparable, V any] struct {
+	sharding ShardingFunc[K, V]
+	shards   []map[K]V
+	mask     uint32
+}
+
+// Snapshot returns a copy of the map as it was at a single point in time.
+// Unlike the per-shard copies made by IterBuffered or Items, it can not
+// observe half of an update spanning several shards: every shard is read
+// locked, in order, before any of them is copied, so writers are held back
+// while the copy is made.
+func (m ConcurrentMap[K, V]) Snapshot() Snapshot[K, V] {
+	for {
+		t := m.settle()
+		if snap, ok := m.snapshotTable(t); ok {
+			return snap
+		}
+	}
+}
+
+// snapshotTable copies every shard of t, or returns false if t was retired by Reshard meanwhile.
+func (m ConcurrentMap[K, V]) snapshotTable(t *shardTable[K, V]) (Snapshot[K, V], bool) {
+	for _, shard := range t.shards {
+		shard.mux.RLock()
+		defer shard.mux.RUnlock()
+
+		if shard.moved.Load() {
+			return Snapshot[K, V]{}, false
+		}
+	}
+
+	snap := Snapshot[K, V]{
+		sharding: m.sharding,
+		shards:   make([]map[K]V, len(t.shards)),
+		mask:     t.mask,
+	}
+	for i, shard := range t.shards {
+		snap.shards[i] = shard.clone()
+	}
+	return snap, true
+}
+
+// Get retrieves an element from the snapshot under given key.
+func (s Snapshot[K, V]) Get(key K) (V, bool) {
+	v, ok := s.shards[s.sharding(key)&s.mask][key]
+	return v, ok
+}
+
+// Has looks up an item under specified key.
+func (s Snapshot[K, V]) Has(key K) bool {
+	_, ok := s.Get(key)
+	return ok
+}
+
+// Count returns the number of elements within the snapshot.
+func (s Snapshot[K, V]) Count() int {
+	count := 0
+	for _, shard := range s.shards {
+		count += len(shard)
+	}
+	return count
+}
+
+// All returns an iterator over all key-value pairs of the snapshot.
+func (s Snapshot[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, shard := range s.shards {
+			for k, v := range shard {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Items returns all items as map[K]V.
+func (s Snapshot[K, V]) Items() map[K]V {
+	tmp := make(map[K]V, s.Count())
+	for k, v := range s.All() {
+		tmp[k] = v
+	}
+	return tmp
+}
+
+// Marshals the snapshot as a single JSON object.
+func (s Snapshot[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Items())
+}
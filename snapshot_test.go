@@ -0,0 +1,119 @@
+package cmap
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// 测试快照的读取方法
+func TestSnapshot(t *testing.T) {
+	m := New[int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	snap := m.Snapshot()
+
+	// 快照不受之后修改的影响
+	m.Set("100", 100)
+	m.Remove("0")
+
+	if snap.Count() != 100 {
+		t.Errorf("快照应包含100个元素，实际为 %d", snap.Count())
+	}
+	if v, ok := snap.Get("0"); !ok || v != 0 {
+		t.Error("快照中应该存在键0")
+	}
+	if snap.Has("100") {
+		t.Error("快照中不应该存在键100")
+	}
+
+	sum := 0
+	for _, v := range snap.All() {
+		sum += v
+	}
+	if sum != 4950 {
+		t.Errorf("值之和应为4950，实际为 %d", sum)
+	}
+
+	if len(snap.Items()) != 100 {
+		t.Error("Items应该返回100个元素")
+	}
+
+	j, err := json.Marshal(snap)
+	if err != nil {
+		t.Error(err)
+	}
+	items := make(map[string]int)
+	if err := json.Unmarshal(j, &items); err != nil || len(items) != 100 {
+		t.Errorf("快照的json不正确: %s", j)
+	}
+}
+
+// 测试快照在所有分片上是一致的
+func TestSnapshotConsistent(t *testing.T) {
+	// a 位于分片0，b 位于分片1
+	m := NewWithOptions(Options[string, int]{
+		ShardCount: 2,
+		Sharding: func(key string) uint32 {
+			if key == "a" {
+				return 0
+			}
+			return 1
+		},
+	})
+	m.Set("a", 0)
+	m.Set("b", 0)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// 先写a再写b，所以任意时刻都有 b <= a
+			m.Set("a", i)
+			m.Set("b", i)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		snap := m.Snapshot()
+		a, _ := snap.Get("a")
+		b, _ := snap.Get("b")
+		if b > a {
+			t.Fatalf("快照观察到了不一致的状态: a=%d b=%d", a, b)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// 测试重新分片期间的快照
+func TestSnapshotWhileResharding(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 4})
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{64, 2, 128, 8} {
+			m.Reshard(n)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if snap := m.Snapshot(); snap.Count() != 1000 {
+			t.Errorf("快照应包含1000个元素，实际为 %d", snap.Count())
+		}
+	}
+	<-done
+}
@@ -0,0 +1,125 @@
+package cmap
+
+import (
+	"math/bits"
+)
+
+// Admission selects which new keys a map bounded by Options.MaxEntries
+// admits when a shard is full.
+type Admission int
+
+const (
+	// AdmitAll admits every new key and evicts the least recently used
+	// entry, which is plain LRU.
+	AdmitAll Admission = iota
+	// TinyLFU uses W-TinyLFU: new keys enter a small LRU window holding
+	// 1% of the shard, and a key leaving the window only displaces the
+	// least recently used entry of the rest of the shard if it is
+	// estimated to be accessed more often. Frequencies are estimated by
+	// a count-min sketch behind a doorkeeper bloom filter, which keeps
+	// one-off keys out of the sketch, and are halved periodically so
+	// that old popularity fades. This protects the cache from scans.
+	TinyLFU
+)
+
+// sketchDepth is the number of rows of the count-min sketch.
+const sketchDepth = 4
+
+// maxFrequency is the largest count of the sketch.
+const maxFrequency = 15
+
+// frequencySketch estimates how often keys were accessed recently.
+type frequencySketch struct {
+	// counts holds sketchDepth rows of len(counts)/sketchDepth counters.
+	counts []uint8
+	mask   uint32
+	// doorkeeper is a bloom filter of the keys seen since the last reset.
+	// The sketch only counts a key from its second access, so keys seen
+	// once do not pollute it.
+	doorkeeper []uint64
+	doorMask   uint32
+	// additions counts the accesses since the last reset.
+	additions  int
+	sampleSize int
+}
+
+// newFrequencySketch creates a sketch for a shard holding up to limit keys.
+// Each row has about 4 counters per key, counts are halved every
+// 10*limit accesses, and the doorkeeper has about 8 bits per key of such
+// a sample.
+func newFrequencySketch(limit int) *frequencySketch {
+	limit = max(limit, 16)
+	width := 1 << bits.Len(uint(4*limit-1))
+	sampleSize := 10 * limit
+	doorBits := 1 << bits.Len(uint(8*sampleSize-1))
+	return &frequencySketch{
+		counts:     make([]uint8, sketchDepth*width),
+		mask:       uint32(width - 1),
+		doorkeeper: make([]uint64, doorBits/64),
+		doorMask:   uint32(doorBits - 1),
+		sampleSize: sampleSize,
+	}
+}
+
+// sketchHashes derives two hashes of h for double hashing.
+func sketchHashes(h uint32) (h1, h2 uint32) {
+	x := mix64(uint64(h))
+	return uint32(x), uint32(x>>32) | 1
+}
+
+// counter returns the counter of row i for the hashes h1, h2.
+func (s *frequencySketch) counter(i int, h1, h2 uint32) *uint8 {
+	return &s.counts[uint32(i)*(s.mask+1)+(h1+uint32(i)*h2)&s.mask]
+}
+
+// doorBit returns the word and mask of the i-th doorkeeper bit for the hashes h1, h2.
+func (s *frequencySketch) doorBit(i int, h1, h2 uint32) (*uint64, uint64) {
+	bit := (h1 + uint32(i+sketchDepth)*h2) & s.doorMask
+	return &s.doorkeeper[bit/64], 1 << (bit % 64)
+}
+
+// increment records an access to the key hashing to h.
+func (s *frequencySketch) increment(h uint32) {
+	h1, h2 := sketchHashes(h)
+	seen := true
+	for i := 0; i < sketchDepth; i++ {
+		if word, mask := s.doorBit(i, h1, h2); *word&mask == 0 {
+			seen = false
+			*word |= mask
+		}
+	}
+	if seen {
+		for i := 0; i < sketchDepth; i++ {
+			if c := s.counter(i, h1, h2); *c < maxFrequency {
+				*c++
+			}
+		}
+	}
+	if s.additions++; s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate returns the estimated number of recent accesses to the key hashing to h.
+func (s *frequencySketch) estimate(h uint32) int {
+	h1, h2 := sketchHashes(h)
+	freq := maxFrequency
+	for i := 0; i < sketchDepth; i++ {
+		freq = min(freq, int(*s.counter(i, h1, h2)))
+	}
+	for i := 0; i < sketchDepth; i++ {
+		if word, mask := s.doorBit(i, h1, h2); *word&mask == 0 {
+			return freq
+		}
+	}
+	return freq + 1
+}
+
+// reset halves every counter and clears the doorkeeper.
+func (s *frequencySketch) reset() {
+	for i := range s.counts {
+		s.counts[i] >>= 1
+	}
+	clear(s.doorkeeper)
+	s.additions /= 2
+}
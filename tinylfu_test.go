@@ -0,0 +1,185 @@
+package cmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// 测试频率估计和门卫过滤
+func TestFrequencySketch(t *testing.T) {
+	s := newFrequencySketch(64)
+	if s.estimate(1) != 0 {
+		t.Error("未访问过的键频率应为0")
+	}
+
+	// 第一次访问只记录在门卫中
+	s.increment(1)
+	if s.estimate(1) != 1 {
+		t.Errorf("访问1次后频率应为1，实际为 %d", s.estimate(1))
+	}
+	for i := 0; i < 5; i++ {
+		s.increment(1)
+	}
+	if s.estimate(1) != 6 {
+		t.Errorf("访问6次后频率应为6，实际为 %d", s.estimate(1))
+	}
+
+	// 计数有上限
+	for i := 0; i < 100; i++ {
+		s.increment(2)
+	}
+	if s.estimate(2) != maxFrequency+1 {
+		t.Errorf("频率不应超过上限，实际为 %d", s.estimate(2))
+	}
+
+	// 衰减时计数减半并清空门卫
+	s.reset()
+	if s.estimate(1) != 2 {
+		t.Errorf("衰减后频率应为2，实际为 %d", s.estimate(1))
+	}
+}
+
+// 测试先读取未命中再写入的键只计一次访问
+func TestTinyLFUOneHit(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 1, MaxEntries: 100, Admission: TinyLFU})
+	if _, ok := m.Get(1); !ok {
+		m.Set(1, 1)
+	}
+	m.Set(2, 2)
+
+	l := m.settle().shards[0].lru
+	if n := l.sketch.estimate(l.hash(1)); n != 1 {
+		t.Errorf("只访问一次的键频率应为1，实际为 %d", n)
+	}
+	if n := l.sketch.estimate(l.hash(2)); n != 0 {
+		t.Errorf("只写入的键频率应为0，实际为 %d", n)
+	}
+}
+
+// 测试自定义的分片函数不影响频率估计
+func TestTinyLFUCustomSharding(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{
+		ShardCount: 1,
+		MaxEntries: 100,
+		Admission:  TinyLFU,
+		Sharding:   func(key int) uint32 { return 0 },
+	})
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	// 频繁访问的新键应该替换只写入过的键
+	kept := 0
+	for i := 1000; i < 1100; i++ {
+		for j := 0; j < 5; j++ {
+			m.Get(i)
+		}
+		m.Set(i, i)
+	}
+	for i := 1000; i < 1100; i++ {
+		if m.Has(i) {
+			kept++
+		}
+	}
+	if kept < 90 {
+		t.Errorf("频繁访问的新键应该保留，实际只保留了 %d 个", kept)
+	}
+}
+
+// 测试只访问一次的键不会挤掉频繁访问的键
+func TestTinyLFUAdmission(t *testing.T) {
+	run := func(admission Admission) (kept int) {
+		m := NewWithOptions(Options[int, int]{ShardCount: 1, MaxEntries: 100, Admission: admission})
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 100; i++ {
+				if _, ok := m.Get(i); !ok {
+					m.Set(i, i)
+				}
+			}
+		}
+		// 一次性扫描
+		for i := 1000; i < 2000; i++ {
+			m.Set(i, i)
+		}
+		if m.Count() != 100 {
+			t.Errorf("元素数量应为100，实际为 %d", m.Count())
+		}
+		for i := 0; i < 100; i++ {
+			if m.Has(i) {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	if kept := run(AdmitAll); kept != 0 {
+		t.Errorf("LRU 应淘汰所有频繁访问的键，实际保留了 %d 个", kept)
+	}
+	// 频率估计可能因哈希冲突而偏高，允许少量误差
+	if kept := run(TinyLFU); kept < 90 {
+		t.Errorf("频繁访问的键应该保留，实际只保留了 %d 个", kept)
+	}
+}
+
+// 测试在包含扫描的负载下 TinyLFU 的命中率高于 LRU
+func TestTinyLFUHitRatio(t *testing.T) {
+	run := func(admission Admission) float64 {
+		m := NewWithOptions(Options[uint64, uint64]{
+			ShardCount: 4,
+			MaxEntries: 1000,
+			Admission:  admission,
+		})
+		r := rand.New(rand.NewSource(1))
+		zipf := rand.NewZipf(r, 1.1, 1, 100000)
+		scan := uint64(1 << 32)
+		for i := 0; i < 200000; i++ {
+			key := zipf.Uint64()
+			if i%1000 < 300 {
+				// 每1000次请求中有300次访问只出现一次的键
+				key = scan
+				scan++
+			}
+			if _, ok := m.Get(key); !ok {
+				m.Set(key, key)
+			}
+		}
+		return m.Stats().HitRatio()
+	}
+
+	lru, tinyLFU := run(AdmitAll), run(TinyLFU)
+	t.Logf("命中率: LRU %.3f, TinyLFU %.3f", lru, tinyLFU)
+	if tinyLFU <= lru {
+		t.Errorf("TinyLFU 的命中率 %.3f 应高于 LRU 的 %.3f", tinyLFU, lru)
+	}
+}
+
+// 测试 TinyLFU 的窗口和主区域数量一致
+func TestTinyLFUConsistency(t *testing.T) {
+	m := NewWithOptions(Options[int, int]{ShardCount: 2, MaxEntries: 200, Admission: TinyLFU})
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 10000; i++ {
+		key := r.Intn(1000)
+		switch r.Intn(4) {
+		case 0:
+			m.Remove(key)
+		case 1:
+			m.Get(key)
+		default:
+			m.Set(key, i)
+		}
+	}
+	m.Reshard(4)
+	for _, shard := range m.settle().shards {
+		l := shard.lru
+		win := 0
+		for n := l.win.next; n != &l.win; n = n.next {
+			win++
+		}
+		main := 0
+		for n := l.root.next; n != &l.root; n = n.next {
+			main++
+		}
+		if win != l.winLen || win+main != len(l.nodes) || len(l.nodes) != len(shard.m) || len(shard.m) > l.limit {
+			t.Errorf("窗口 %d/%d 主区域 %d 记录 %d 元素 %d 上限 %d", win, l.winLen, main, len(l.nodes), len(shard.m), l.limit)
+		}
+	}
+}
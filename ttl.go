@@ -0,0 +1,223 @@
+package cmap
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultJanitorInterval is how often expired entries are reclaimed when
+// Options.JanitorInterval is zero.
+const defaultJanitorInterval = time.Second
+
+// NoExpiration is the TTL reported for entries that never expire.
+const NoExpiration time.Duration = -1
+
+// janitor reclaims expired entries in the background.
+// It starts the first time a TTL is set and runs until Close.
+type janitor struct {
+	interval time.Duration
+	start    sync.Once
+	stop     sync.Once
+	done     chan struct{}
+}
+
+// SetWithTTL sets the given value under the specified key for ttl.
+// Once it expires, the entry is invisible to every read, write and
+// iteration, as if it had been removed, and it is eventually reclaimed by
+// the janitor. A ttl <= 0 removes the key, since the entry would expire
+// at once. Writes that replace the entry without a TTL, like Set, make it
+// permanent again.
+func (m ConcurrentMap[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		m.Remove(key)
+		return
+	}
+	m.update(key, func(s *SafeMap[K, V]) {
+		s.put(key, value, s.weigh(value), s.deadline(ttl))
+	})
+	m.startJanitor()
+}
+
+// ExpireAt makes the element under key expire at the given time, removing
+// it if that time has passed. It returns false if the key is not present.
+func (m ConcurrentMap[K, V]) ExpireAt(key K, at time.Time) (ok bool) {
+	deadline := at.UnixNano()
+	m.update(key, func(s *SafeMap[K, V]) {
+		if _, ok = s.load(key); !ok {
+			return
+		}
+		if deadline <= s.now() {
+			s.drop(key, EvictExpired)
+		} else {
+			s.expire(key, deadline)
+		}
+	})
+	if ok {
+		m.startJanitor()
+	}
+	return
+}
+
+// TTL returns the time left before the element under key expires, or
+// NoExpiration if it never does. ok is false if the key is not present.
+func (m ConcurrentMap[K, V]) TTL(key K) (ttl time.Duration, ok bool) {
+	m.GetShard(key).view(func(s *SafeMap[K, V]) {
+		if _, ok = s.load(key); !ok {
+			return
+		}
+		ttl = NoExpiration
+		if deadline := s.expiresAt(key); deadline != 0 {
+			ttl = time.Duration(deadline - s.now())
+		}
+	})
+	return
+}
+
+// Persist removes the expiration of the element under key.
+// It returns true if the element was present and had one.
+func (m ConcurrentMap[K, V]) Persist(key K) (ok bool) {
+	m.update(key, func(s *SafeMap[K, V]) {
+		if _, present := s.load(key); present {
+			ok = s.persist(key)
+		}
+	})
+	return
+}
+
+// Close stops the janitor. Expired entries stay invisible but are only
+// reclaimed when overwritten or removed. A map that ever had a TTL set
+// should be closed once it is no longer used, since the janitor keeps
+// it alive. Close may be called more than once.
+func (m ConcurrentMap[K, V]) Close() {
+	j := &m.state.janitor
+	j.stop.Do(func() {
+		close(j.done)
+	})
+}
+
+// startJanitor starts the janitor, unless it is already running or the map is closed.
+func (m ConcurrentMap[K, V]) startJanitor() {
+	m.state.janitor.start.Do(func() {
+		go m.sweep()
+	})
+}
+
+// sweep reclaims the expired entries of every shard at each tick of the
+// janitor, locking one shard at a time, until the map is closed.
+func (m ConcurrentMap[K, V]) sweep() {
+	j := &m.state.janitor
+	interval := j.interval
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+		}
+		for _, shard := range m.settle().shards {
+			shard.reclaim()
+		}
+	}
+}
+
+// expiry is the deadline of a key, kept in the deadline heap of its shard.
+type expiry[K comparable] struct {
+	deadline int64
+	// order is the deadline the heap is ordered by. Postponing a deadline
+	// does not move the expiry, so order may be earlier than deadline
+	// until purge finds the expiry and moves it down.
+	order int64
+	key   K
+	// index is the position of the expiry in the heap.
+	index int
+}
+
+// expiryHeap is a min-heap of deadlines, so the expired keys of a shard
+// are found without visiting the keys that are still alive.
+type expiryHeap[K comparable] []*expiry[K]
+
+func (h *expiryHeap[K]) push(e *expiry[K]) {
+	e.index = len(*h)
+	*h = append(*h, e)
+	h.up(e.index)
+}
+
+// remove removes the expiry at position i.
+func (h *expiryHeap[K]) remove(i int) {
+	s := *h
+	last := len(s) - 1
+	if i != last {
+		h.swap(i, last)
+	}
+	s[last] = nil
+	*h = s[:last]
+	if i != last {
+		h.fix(i)
+	}
+}
+
+// fix restores the heap after the deadline at position i changed.
+func (h expiryHeap[K]) fix(i int) {
+	if !h.down(i) {
+		h.up(i)
+	}
+}
+
+func (h expiryHeap[K]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h[parent].order <= h[i].order {
+			return
+		}
+		h.swap(parent, i)
+		i = parent
+	}
+}
+
+// down moves the expiry at position i down and reports whether it moved.
+func (h expiryHeap[K]) down(i int) bool {
+	start := i
+	for {
+		least := i
+		if l := 2*i + 1; l < len(h) && h[l].order < h[least].order {
+			least = l
+		}
+		if r := 2*i + 2; r < len(h) && h[r].order < h[least].order {
+			least = r
+		}
+		if least == i {
+			return i > start
+		}
+		h.swap(i, least)
+		i = least
+	}
+}
+
+func (h expiryHeap[K]) swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+// expired calls fn for every expiry with a deadline at or before now.
+// It only visits the expiries ordered at or before now and their
+// children, so it costs time proportional to the number of expired keys
+// and of keys postponed since the last purge.
+func (h expiryHeap[K]) expired(now int64, fn func(e *expiry[K])) {
+	h.visit(0, now, fn)
+}
+
+func (h expiryHeap[K]) visit(i int, now int64, fn func(e *expiry[K])) {
+	if i >= len(h) || h[i].order > now {
+		return
+	}
+	if h[i].deadline <= now {
+		fn(h[i])
+	}
+	h.visit(2*i+1, now, fn)
+	h.visit(2*i+2, now, fn)
+}
@@ -0,0 +1,267 @@
+package cmap
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 是测试用的可手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// rawCount 返回包括已过期但尚未回收的元素在内的数量
+func rawCount[K comparable, V any](m ConcurrentMap[K, V]) int {
+	n := 0
+	for _, shard := range m.settle().shards {
+		shard.mux.RLock()
+		n += len(shard.m)
+		shard.mux.RUnlock()
+	}
+	return n
+}
+
+// 测试过期的元素对读取和遍历不可见
+func TestTTLExpiry(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[string, int]{Clock: clock.Now})
+	defer m.Close()
+
+	m.SetWithTTL("short", 1, time.Second)
+	m.SetWithTTL("long", 2, time.Hour)
+	m.Set("forever", 3)
+
+	if v, ok := m.Get("short"); !ok || v != 1 {
+		t.Error("未过期的元素应该可见")
+	}
+	if ttl, ok := m.TTL("short"); !ok || ttl != time.Second {
+		t.Errorf("TTL() = %v, %v", ttl, ok)
+	}
+	if ttl, ok := m.TTL("forever"); !ok || ttl != NoExpiration {
+		t.Errorf("永不过期的元素 TTL() = %v, %v", ttl, ok)
+	}
+	if _, ok := m.TTL("missing"); ok {
+		t.Error("不存在的键 TTL 应返回 false")
+	}
+
+	clock.Advance(2 * time.Second)
+
+	if _, ok := m.Get("short"); ok || m.Has("short") {
+		t.Error("过期的元素不应可见")
+	}
+	if _, ok := m.TTL("short"); ok {
+		t.Error("过期的元素 TTL 应返回 false")
+	}
+	if m.Count() != 2 {
+		t.Errorf("Count() 应为2，实际为 %d", m.Count())
+	}
+	keys := m.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"forever", "long"}) {
+		t.Errorf("Keys() = %v", keys)
+	}
+	if _, ok := m.Items()["short"]; ok {
+		t.Error("Items() 不应包含过期的元素")
+	}
+	for k := range m.All() {
+		if k == "short" {
+			t.Error("All() 不应包含过期的元素")
+		}
+	}
+	if m.Snapshot().Has("short") {
+		t.Error("快照不应包含过期的元素")
+	}
+	if len(m.MGet([]string{"short", "long"})) != 1 {
+		t.Error("MGet() 不应返回过期的元素")
+	}
+	_, batch := m.Scan(0, 100)
+	if len(batch) != 2 {
+		t.Errorf("Scan() 应返回2个元素，实际为 %d", len(batch))
+	}
+
+	// 过期的键视为不存在
+	if !m.SetIfAbsent("short", 10) {
+		t.Error("过期的键应该可以被 SetIfAbsent 写入")
+	}
+	if ttl, _ := m.TTL("short"); ttl != NoExpiration {
+		t.Error("SetIfAbsent 写入的元素不应过期")
+	}
+}
+
+// 测试 ExpireAt 和 Persist
+func TestTTLExpireAtPersist(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[string, int]{Clock: clock.Now})
+	defer m.Close()
+
+	m.Set("key", 1)
+	if m.ExpireAt("missing", clock.Now().Add(time.Second)) {
+		t.Error("不存在的键 ExpireAt 应返回 false")
+	}
+	if !m.ExpireAt("key", clock.Now().Add(time.Minute)) {
+		t.Error("ExpireAt 应返回 true")
+	}
+	if ttl, _ := m.TTL("key"); ttl != time.Minute {
+		t.Errorf("TTL() = %v", ttl)
+	}
+
+	if !m.Persist("key") {
+		t.Error("Persist 应返回 true")
+	}
+	if m.Persist("key") || m.Persist("missing") {
+		t.Error("没有过期时间的键 Persist 应返回 false")
+	}
+	clock.Advance(time.Hour)
+	if !m.Has("key") {
+		t.Error("Persist 后元素不应过期")
+	}
+
+	// 过去的时间立即删除
+	if !m.ExpireAt("key", clock.Now().Add(-time.Second)) || m.Has("key") || rawCount(m) != 0 {
+		t.Error("ExpireAt 过去的时间应删除元素")
+	}
+
+	// Set 清除过期时间
+	m.SetWithTTL("key", 1, time.Second)
+	m.Set("key", 2)
+	clock.Advance(time.Hour)
+	if v, ok := m.Get("key"); !ok || v != 2 {
+		t.Error("Set 应清除过期时间")
+	}
+
+	// ttl <= 0 删除键
+	m.SetWithTTL("key", 3, 0)
+	if m.Has("key") {
+		t.Error("ttl <= 0 应删除键")
+	}
+}
+
+// 测试后台清理过期的元素
+func TestTTLJanitor(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[int, int]{
+		Clock:           clock.Now,
+		JanitorInterval: time.Millisecond,
+	})
+	defer m.Close()
+
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(i, i, time.Duration(i+1)*time.Second)
+	}
+	clock.Advance(50 * time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rawCount(m) != 50 {
+		if time.Now().After(deadline) {
+			t.Fatalf("过期的元素没有被回收，剩余 %d 个", rawCount(m))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if m.Count() != 50 {
+		t.Errorf("Count() 应为50，实际为 %d", m.Count())
+	}
+
+	// 关闭后不再回收
+	m.Close()
+	m.Close()
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if rawCount(m) != 50 || m.Count() != 0 {
+		t.Error("关闭后过期的元素应不可见，但不再被回收")
+	}
+}
+
+// 测试扩容时保留过期时间
+func TestTTLReshard(t *testing.T) {
+	clock := newFakeClock()
+	m := NewWithOptions(Options[int, int]{Clock: clock.Now, ShardCount: 2})
+	defer m.Close()
+
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			m.SetWithTTL(i, i, time.Minute)
+		} else {
+			m.Set(i, i)
+		}
+	}
+	m.Reshard(16)
+
+	if ttl, ok := m.TTL(0); !ok || ttl != time.Minute {
+		t.Errorf("扩容后 TTL() = %v, %v", ttl, ok)
+	}
+	clock.Advance(time.Hour)
+	if m.Count() != 50 {
+		t.Errorf("Count() 应为50，实际为 %d", m.Count())
+	}
+	m.Reshard(4)
+	if rawCount(m) != 50 {
+		t.Error("扩容时不应迁移过期的元素")
+	}
+}
+
+// 测试随机设置和清除过期时间后，过期时间堆与 expires 一致，且元素数量正确
+func TestExpiryHeap(t *testing.T) {
+	clock := newFakeClock()
+	sm := NewSafe[int, int]()
+	sm.clock = clock.Now
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 10000; i++ {
+		key := rnd.Intn(200)
+		switch rnd.Intn(4) {
+		case 0:
+			sm.put(key, i, 1, sm.deadline(time.Duration(rnd.Intn(100)+1)*time.Second))
+		case 1:
+			sm.put(key, i, 1, 0)
+		case 2:
+			sm.delete(key)
+		case 3:
+			clock.Advance(time.Duration(rnd.Intn(10)) * time.Second)
+		}
+		if i%100 == 0 {
+			sm.purge()
+		}
+
+		if len(sm.deadlines) != len(sm.expires) {
+			t.Fatalf("堆中有 %d 个元素，expires 中有 %d 个", len(sm.deadlines), len(sm.expires))
+		}
+		for j, e := range sm.deadlines {
+			if e.index != j || sm.expires[e.key] != e {
+				t.Fatalf("位置 %d 的过期时间与索引不一致", j)
+			}
+			if e.order > e.deadline || j > 0 && sm.deadlines[(j-1)/2].order > e.order {
+				t.Fatalf("位置 %d 违反了堆的顺序", j)
+			}
+		}
+		want := 0
+		for k := range sm.m {
+			if !sm.expired(k, sm.now()) {
+				want++
+			}
+		}
+		if n := sm.size(); n != want {
+			t.Fatalf("size() = %d，期望 %d", n, want)
+		}
+	}
+}
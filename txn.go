@@ -0,0 +1,149 @@
+package cmap
+
+import (
+	"slices"
+)
+
+// Tx gives a Txn callback access to the keys of the transaction.
+// Writes are buffered and only applied if the callback returns nil.
+type Tx[K comparable, V any] struct {
+	table  *shardTable[K, V]
+	hashes map[K]uint32
+	writes map[K]txWrite[V]
+}
+
+type txWrite[V any] struct {
+	value   V
+	deleted bool
+}
+
+// shard returns the locked shard of a key of the transaction, panicking for any other key.
+func (tx *Tx[K, V]) shard(key K) *SafeMap[K, V] {
+	h, ok := tx.hashes[key]
+	if !ok {
+		panic("cmap: key not declared in Txn")
+	}
+	return tx.table.shards[h&tx.table.mask]
+}
+
+// Get retrieves the value of key, including writes made earlier in the transaction.
+func (tx *Tx[K, V]) Get(key K) (V, bool) {
+	shard := tx.shard(key)
+	if w, ok := tx.writes[key]; ok {
+		return w.value, !w.deleted
+	}
+	return shard.load(key)
+}
+
+// Set sets the value of key when the transaction commits.
+func (tx *Tx[K, V]) Set(key K, value V) {
+	tx.shard(key)
+	tx.writes[key] = txWrite[V]{value: value}
+}
+
+// Delete removes key when the transaction commits.
+func (tx *Tx[K, V]) Delete(key K) {
+	tx.shard(key)
+	tx.writes[key] = txWrite[V]{deleted: true}
+}
+
+// Txn runs fn atomically over keys, which may live in different shards.
+// The write locks of all involved shards are taken in shard order, so
+// concurrent transactions can not deadlock, and held while fn runs.
+// fn may only access the given keys; its writes are applied when it
+// returns nil and discarded when it returns an error, which Txn returns.
+func (m ConcurrentMap[K, V]) Txn(keys []K, fn func(tx *Tx[K, V]) error) error {
+	hashes := make(map[K]uint32, len(keys))
+	for _, key := range keys {
+		hashes[key] = m.sharding(key)
+	}
+
+	for {
+		t := m.state.table.Load()
+		order := make([]uint32, 0, len(hashes))
+		for _, h := range hashes {
+			m.migrate(t, h)
+			order = append(order, h&t.mask)
+		}
+		slices.Sort(order)
+		order = slices.Compact(order)
+
+		if !lockShards(t, order) {
+			continue
+		}
+		tx := &Tx[K, V]{
+			table:  t,
+			hashes: hashes,
+			writes: make(map[K]txWrite[V]),
+		}
+		return m.commit(tx, order, fn)
+	}
+}
+
+// lockShards write locks the shards of t listed in order.
+// It returns false, holding no lock, if one of them was retired by Reshard.
+func lockShards[K comparable, V any](t *shardTable[K, V], order []uint32) bool {
+	for n, i := range order {
+		shard := t.shards[i]
+		shard.mux.Lock()
+		if shard.moved.Load() {
+			for _, j := range order[:n+1] {
+				t.shards[j].mux.Unlock()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// unlockShards unlocks the shards of t listed in order, then notifies the
+// listeners of the entries removed meanwhile, so they may use the map.
+func (m ConcurrentMap[K, V]) unlockShards(t *shardTable[K, V], order []uint32) {
+	var pending []evicted[K, V]
+	for _, i := range order {
+		pending = append(pending, t.shards[i].detach()...)
+		t.shards[i].mux.Unlock()
+	}
+	if len(pending) > 0 {
+		m.state.listeners.notify(pending)
+	}
+}
+
+// commit runs fn with the shards of order locked and applies its writes if it succeeds.
+func (m ConcurrentMap[K, V]) commit(tx *Tx[K, V], order []uint32, fn func(tx *Tx[K, V]) error) error {
+	t := tx.table
+	before := make([]int, len(order))
+	after := make([]int, len(order))
+	err := func() error {
+		defer m.unlockShards(t, order)
+
+		for n, i := range order {
+			before[n] = len(t.shards[i].m)
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		for key, w := range tx.writes {
+			shard := tx.shard(key)
+			if w.deleted {
+				shard.delete(key)
+			} else {
+				shard.store(key, w.value)
+			}
+		}
+		for n, i := range order {
+			after[n] = len(t.shards[i].m)
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	for n, i := range order {
+		if after[n] > before[n] {
+			m.grown(t, int(i), before[n], after[n])
+		}
+	}
+	return nil
+}
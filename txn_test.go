@@ -0,0 +1,145 @@
+package cmap
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// 测试事务提交和回滚
+func TestTxn(t *testing.T) {
+	m := New[int]()
+	m.Set("a", 100)
+	m.Set("b", 0)
+	m.Set("c", 1)
+
+	err := m.Txn([]string{"a", "b", "c", "d"}, func(tx *Tx[string, int]) error {
+		a, _ := tx.Get("a")
+		tx.Set("a", a-30)
+		tx.Set("b", 30)
+		tx.Delete("c")
+		tx.Set("d", 4)
+
+		// 事务内可以读到之前的写入
+		if v, ok := tx.Get("b"); !ok || v != 30 {
+			t.Error("事务内应该读到之前的写入")
+		}
+		if _, ok := tx.Get("c"); ok {
+			t.Error("事务内删除的键不应该存在")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("事务不应该失败: %v", err)
+	}
+
+	if v, _ := m.Get("a"); v != 70 {
+		t.Errorf("a 应为70，实际为 %d", v)
+	}
+	if v, _ := m.Get("b"); v != 30 {
+		t.Errorf("b 应为30，实际为 %d", v)
+	}
+	if m.Has("c") {
+		t.Error("c 应该被删除")
+	}
+	if v, _ := m.Get("d"); v != 4 {
+		t.Errorf("d 应为4，实际为 %d", v)
+	}
+
+	// 回调返回错误时不应用任何写入
+	errAbort := errors.New("abort")
+	err = m.Txn([]string{"a", "b"}, func(tx *Tx[string, int]) error {
+		tx.Set("a", 0)
+		tx.Delete("b")
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("应该返回回调的错误，实际为 %v", err)
+	}
+	if v, _ := m.Get("a"); v != 70 {
+		t.Errorf("回滚后 a 应为70，实际为 %d", v)
+	}
+	if !m.Has("b") {
+		t.Error("回滚后 b 应该存在")
+	}
+}
+
+// 测试访问未声明的键时panic
+func TestTxnUndeclaredKey(t *testing.T) {
+	m := New[int]()
+	defer func() {
+		if recover() == nil {
+			t.Error("访问未声明的键应该panic")
+		}
+		// panic后锁应该被释放
+		m.Set("a", 1)
+		m.Set("b", 1)
+	}()
+	m.Txn([]string{"a"}, func(tx *Tx[string, int]) error {
+		tx.Get("b")
+		return nil
+	})
+}
+
+// 测试并发转账时总额保持不变
+func TestTxnConcurrentTransfers(t *testing.T) {
+	m := NewWithOptions(Options[string, int]{ShardCount: 4})
+	const accounts = 10
+	for i := 0; i < accounts; i++ {
+		m.Set(strconv.Itoa(i), 100)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				from := strconv.Itoa((w + i) % accounts)
+				to := strconv.Itoa((w + 2*i + 1) % accounts)
+				m.Txn([]string{from, to}, func(tx *Tx[string, int]) error {
+					a, _ := tx.Get(from)
+					b, _ := tx.Get(to)
+					if from == to || a < 1 {
+						return nil
+					}
+					tx.Set(from, a-1)
+					tx.Set(to, b+1)
+					return nil
+				})
+			}
+		}(w)
+	}
+
+	// 一致的快照中总额始终不变
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			total := 0
+			for _, v := range m.Snapshot().All() {
+				total += v
+			}
+			if total != accounts*100 {
+				t.Errorf("快照中总额应为 %d，实际为 %d", accounts*100, total)
+				return
+			}
+		}
+	}()
+
+	// 并发重新分片
+	for _, n := range []int{16, 2, 8} {
+		m.Reshard(n)
+	}
+	wg.Wait()
+	<-done
+
+	total := 0
+	for _, v := range m.Items() {
+		total += v
+	}
+	if total != accounts*100 {
+		t.Errorf("总额应为 %d，实际为 %d", accounts*100, total)
+	}
+}